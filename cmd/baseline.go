@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ichbinfrog/excavator/pkg/scan"
+
+	"github.com/spf13/cobra"
+)
+
+var baseline string
+
+var baselineCmd = &cobra.Command{
+	Use:   "baseline",
+	Short: "manage baselines of known findings",
+	Long: `Command to manage baselines, a baseline is a list of known findings
+(reviewed leaks, false positives, ...) which are not reported by the
+scans given the --baseline flag.`,
+}
+
+var baselineCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "write the findings of a scan as a baseline",
+	Long: `Command to scan a git repository or a directory and write
+its findings as a baseline (defaults to .excavator-baseline.json,
+see --output).
+
+  excavator baseline create git . -o .excavator-baseline.json
+  excavator git . --baseline .excavator-baseline.json`,
+}
+
+// newBaselineReport returns the report writing the baseline
+func newBaselineReport() (scan.ReportInterface, error) {
+	return &scan.BaselineReport{Outfile: output}, nil
+}
+
+// loadBaseline reads the baseline given by --baseline if any
+func loadBaseline() (*scan.Baseline, error) {
+	if baseline == "" {
+		return nil, nil
+	}
+	b, err := scan.LoadBaseline(baseline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load baseline %s: %w", baseline, err)
+	}
+	return b, nil
+}
+
+func init() {
+	baselineCreateCmd.AddCommand(newGitScanCmd(newBaselineReport, false))
+	baselineCreateCmd.AddCommand(newFsScanCmd(newBaselineReport, false))
+	baselineCmd.AddCommand(baselineCreateCmd)
+	rootCmd.AddCommand(baselineCmd)
+}
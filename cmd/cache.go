@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+	"github.com/ichbinfrog/excavator/pkg/scan"
+	"github.com/rs/zerolog/log"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	noCache, pruneAll bool
+	cacheDir          string
+	cacheSize         int64
+)
+
+const (
+	noCacheUsage   = "scan every object instead of reusing the findings cached by previous runs"
+	cacheDirUsage  = "directory of the scan cache (defaults to excavator in the cache directory of the user)"
+	cacheSizeUsage = "maximum size in MiB of the cache of a target, the least recently used entries are removed first"
+)
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "manage the scan cache",
+	Long: `Command to manage the scan cache, the git and fs scans record the findings
+of each commit and file in --cache-dir so that subsequent scans with
+the same rules only process new objects.`,
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "remove the cache files created with other rules",
+	Long: `Command to remove the cache files of --cache-dir which were created with
+other rules than --rules (or another version of excavator), --all
+removes every cache file.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setVerbosity()
+		var rs *model.RuleSet
+		if !pruneAll {
+			rs = &model.RuleSet{}
+			if err := rs.ParseConfig(rules); err != nil {
+				return fmt.Errorf("failed to parse rules: %w", err)
+			}
+		}
+		dir, err := cacheDirectory()
+		if err != nil {
+			return fmt.Errorf("failed to locate cache: %w", err)
+		}
+		removed, err := scan.PruneCache(dir, rs)
+		for _, file := range removed {
+			log.Debug().Str("path", file).Msg("Removed cache")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to prune cache %s: %w", dir, err)
+		}
+		log.Info().
+			Int("removed", len(removed)).
+			Msg("Cache pruned")
+		return nil
+	},
+}
+
+// cacheDirectory returns --cache-dir or the default cache directory
+func cacheDirectory() (string, error) {
+	if cacheDir != "" {
+		return cacheDir, nil
+	}
+	return scan.DefaultCacheDir()
+}
+
+// openCache opens the cache of the target unless --no-cache is given,
+// the scan goes on without cache if it can not be read. Local targets
+// are identified by their absolute path.
+func openCache(scanner, target string, rs *model.RuleSet) *scan.Cache {
+	if noCache {
+		return nil
+	}
+	if _, err := os.Stat(target); err == nil {
+		if abs, err := filepath.Abs(target); err == nil {
+			target = abs
+		}
+	}
+	dir, err := cacheDirectory()
+	if err == nil {
+		var c *scan.Cache
+		if c, err = scan.OpenCache(dir, scanner, target, rs); err == nil {
+			c.MaxSize = cacheSize << 20
+			return c
+		}
+	}
+	log.Warn().
+		Str("cache_dir", dir).
+		Err(err).
+		Msg("Failed to open cache, scanning without it")
+	return nil
+}
+
+func init() {
+	flags := cachePruneCmd.Flags()
+	flags.StringVar(&cacheDir, "cache-dir", "", cacheDirUsage)
+	flags.BoolVar(&pruneAll, "all", false, "remove every cache file")
+	cacheCmd.AddCommand(cachePruneCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ichbinfrog/excavator/pkg/scan"
+	"github.com/rs/zerolog/log"
+
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [file|-]",
+	Short: "scan a unified diff",
+	Long: `Command to scan a unified diff (diff -u, git diff, git format-patch)
+read from a file or from the standard input (defaults to -).
+Only the added lines are reported with their line number
+in the new version of each file.
+
+  git diff origin/main... | excavator diff - -f sarif -o -`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setVerbosity()
+		source := scan.Stdin
+		if len(args) > 0 {
+			source = args[0]
+		}
+		log.Debug().
+			Str("diff", source).
+			Str("rules", rules).
+			Str("format", format).
+			Str("baseline", baseline).
+			Str("fail_on", failOn).
+			Msg("Scan initiated with configuration")
+
+		threshold, err := parseFailOn()
+		if err != nil {
+			return err
+		}
+		b, err := loadBaseline()
+		if err != nil {
+			return err
+		}
+		report, err := newReport()
+		if err != nil {
+			return err
+		}
+		s, err := scan.NewDiffScanner(source, rules, report)
+		if err != nil {
+			return fmt.Errorf("failed to initialise scanner for %s: %w", source, err)
+		}
+		s.Baseline = b
+		if _, err := s.Scan(context.Background(), scan.ScanOptions{Concurrent: concurrent}); err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		return checkFindings(s, threshold)
+	},
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&baseline, "baseline", "", "path of the baseline of known findings which are not reported")
+	diffCmd.Flags().StringVar(&failOn, "fail-on", "", failOnUsage)
+	rootCmd.AddCommand(diffCmd)
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ichbinfrog/excavator/pkg/scan"
+)
+
+// Exit codes of the scans
+const (
+	// No finding at or above the --fail-on threshold
+	exitClean = 0
+	// At least one finding at or above the --fail-on threshold
+	exitFindings = 1
+	// The scan could not be completed (invalid flags, unreachable
+	// repository, report which could not be written, ...)
+	exitError = 2
+)
+
+var failOn string
+
+const failOnUsage = "minimum confidence (entropy, low, medium, high) or weight (0 to 1) of the findings failing the scan (defaults to any finding)"
+
+// errFindings is returned by the commands when a finding is at or above
+// the --fail-on threshold, Execute exits with exitFindings. Any other error
+// returned by a command (errors of the scans and reports) exits with exitError
+// whereas errors logged while scanning a single object (unreadable archive,
+// commit which can not be diffed) do not change the exit code.
+var errFindings = errors.New("findings at or above the --fail-on threshold")
+
+// exitCode returns the exit code of the error returned by a command
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitClean
+	case errors.Is(err, errFindings):
+		return exitFindings
+	}
+	return exitError
+}
+
+// parseFailOn parses the --fail-on threshold
+func parseFailOn() (scan.Threshold, error) {
+	threshold, err := scan.ParseThreshold(failOn)
+	if err != nil {
+		return threshold, fmt.Errorf("invalid --fail-on %q: %w", failOn, err)
+	}
+	return threshold, nil
+}
+
+// checkFindings prints the summary of the scan on the standard error and
+// returns errFindings if a finding is at or above the threshold
+func checkFindings(s scan.Scanner, threshold scan.Threshold) error {
+	leaks := s.Leaks()
+	failing := 0
+	for _, leak := range leaks {
+		if threshold.Exceeded(leak) {
+			failing++
+		}
+	}
+
+	var suppressed int64
+	if rs := s.Rules(); rs != nil {
+		suppressed = rs.Suppressed.Pragma + rs.Suppressed.AllowList
+	}
+	var b *scan.Baseline
+	switch scanner := s.(type) {
+	case *scan.GitScanner:
+		b = scanner.Baseline
+	case *scan.FsScanner:
+		b = scanner.Baseline
+	case *scan.StagedScanner:
+		b = scanner.Baseline
+	case *scan.DiffScanner:
+		b = scanner.Baseline
+	}
+	if b != nil {
+		suppressed += int64(b.Suppressed())
+	}
+
+	fmt.Fprintf(os.Stderr, "excavator: %d findings, %d at or above %s, %d suppressed\n",
+		len(leaks), failing, threshold, suppressed)
+
+	if failing > 0 {
+		return errFindings
+	}
+	return nil
+}
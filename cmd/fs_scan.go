@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"path/filepath"
 
 	"github.com/ichbinfrog/excavator/pkg/scan"
@@ -9,32 +11,67 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var fsScanCmd = &cobra.Command{
-	Use:   "fs",
-	Short: "scan a directory in the filesystem",
-	Long: `Command to scan a local directory in the filesystem.
+// newFsScanCmd returns the command scanning a directory
+// which writes its result to the report returned by newOutput,
+// findings at or above --fail-on exit with a non zero code if failOnFindings
+func newFsScanCmd(newOutput func() (scan.ReportInterface, error), failOnFindings bool) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fs",
+		Short: "scan a directory in the filesystem",
+		Long: `Command to scan a local directory in the filesystem.
 Will loop through each file to verify for possible password,
 access tokens (JWT, aws, gcp, ...) leaks.`,
-	Args: cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		setVerbosity()
-		log.Debug().
-			Str("repo", args[0]).
-			Str("rules", rules).
-			Str("format", format).
-			Int("concurrent", concurrent).
-			Msg("Scan initiated with configuration")
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			setVerbosity()
+			log.Debug().
+				Str("repo", args[0]).
+				Str("rules", rules).
+				Str("format", format).
+				Str("baseline", baseline).
+				Str("fail_on", failOn).
+				Bool("no_cache", noCache).
+				Int("concurrent", concurrent).
+				Msg("Scan initiated with configuration")
 
-		var s *scan.FsScanner
-		if format == "yaml" {
-			s = scan.NewFsScanner(filepath.Clean(args[0]), rules, &scan.YamlReport{}, true)
-		} else {
-			s = scan.NewFsScanner(filepath.Clean(args[0]), rules, &scan.HTMLReport{}, true)
-		}
-		s.Scan(concurrent)
-	},
+			threshold, err := parseFailOn()
+			if err != nil {
+				return err
+			}
+			b, err := loadBaseline()
+			if err != nil {
+				return err
+			}
+			report, err := newOutput()
+			if err != nil {
+				return err
+			}
+			s, err := scan.NewFsScanner(filepath.Clean(args[0]), rules, report, true)
+			if err != nil {
+				return fmt.Errorf("failed to initialise scanner for %s: %w", args[0], err)
+			}
+			s.Baseline = b
+			s.ScanCache = openCache(s.Type(), s.Root, s.RuleSet)
+			if _, err := s.Scan(context.Background(), scan.ScanOptions{Concurrent: concurrent}); err != nil {
+				return fmt.Errorf("scan failed: %w", err)
+			}
+			if failOnFindings {
+				return checkFindings(s, threshold)
+			}
+			return nil
+		},
+	}
+
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&cacheDir, "cache-dir", "", cacheDirUsage)
+	flags.BoolVar(&noCache, "no-cache", false, noCacheUsage)
+	flags.Int64Var(&cacheSize, "cache-size", scan.DefaultCacheSize>>20, cacheSizeUsage)
+	return cmd
 }
 
 func init() {
+	fsScanCmd := newFsScanCmd(newReport, true)
+	fsScanCmd.Flags().StringVar(&baseline, "baseline", "", "path of the baseline of known findings which are not reported")
+	fsScanCmd.Flags().StringVar(&failOn, "fail-on", "", failOnUsage)
 	rootCmd.AddCommand(fsScanCmd)
 }
@@ -1,40 +1,148 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/ichbinfrog/excavator/pkg/scan"
 	"github.com/rs/zerolog/log"
 
 	"github.com/spf13/cobra"
 )
 
-var gitScanCmd = &cobra.Command{
-	Use:   "git",
-	Short: "scan a git repository",
-	Long: `Command to scan a local or remote git repository.
+var (
+	since, until, from, to   string
+	mergePolicy, mode        string
+	maxCommits               int
+	allRefs                  bool
+	includeRefs, excludeRefs []string
+)
+
+// newGitScanCmd returns the command scanning a git repository
+// which writes its result to the report returned by newOutput,
+// findings at or above --fail-on exit with a non zero code if failOnFindings
+func newGitScanCmd(newOutput func() (scan.ReportInterface, error), failOnFindings bool) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "git",
+		Short: "scan a git repository",
+		Long: `Command to scan a local or remote git repository.
 Will loop through each commit to verify for possible password,
 access tokens (JWT, aws, gcp, ...) leaks.`,
-	Args: cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		setVerbosity()
-		log.Debug().
-			Str("path", path).
-			Str("repo", args[0]).
-			Str("rules", rules).
-			Str("format", format).
-			Int("concurrent", concurrent).
-			Msg("Scan initiated with configuration")
-
-		var s *scan.GitScanner
-		if format == "yaml" {
-			s = scan.NewGitScanner(args[0], path, rules, &scan.YamlReport{}, true)
-		} else {
-			s = scan.NewGitScanner(args[0], path, rules, &scan.HTMLReport{}, true)
-		}
-		s.Scan(concurrent)
-	},
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			setVerbosity()
+			log.Debug().
+				Str("path", path).
+				Str("repo", args[0]).
+				Str("rules", rules).
+				Str("format", format).
+				Int("concurrent", concurrent).
+				Str("since", since).
+				Str("until", until).
+				Str("from", from).
+				Str("to", to).
+				Int("max_commits", maxCommits).
+				Str("merge_policy", mergePolicy).
+				Str("mode", mode).
+				Bool("all_refs", allRefs).
+				Strs("include_refs", includeRefs).
+				Strs("exclude_refs", excludeRefs).
+				Str("baseline", baseline).
+				Str("fail_on", failOn).
+				Bool("no_cache", noCache).
+				Msg("Scan initiated with configuration")
+
+			threshold, err := parseFailOn()
+			if err != nil {
+				return err
+			}
+			switch mergePolicy {
+			case scan.FirstParent, scan.AllParents, scan.Combined:
+			default:
+				return fmt.Errorf("unknown merge policy %q, must be (first-parent, all-parents, combined)", mergePolicy)
+			}
+			switch mode {
+			case scan.CommitsMode, scan.BlobsMode:
+			default:
+				return fmt.Errorf("unknown mode %q, must be (commits, blobs)", mode)
+			}
+			sinceDate, err := parseDate("since", since)
+			if err != nil {
+				return err
+			}
+			untilDate, err := parseDate("until", until)
+			if err != nil {
+				return err
+			}
+			b, err := loadBaseline()
+			if err != nil {
+				return err
+			}
+			report, err := newOutput()
+			if err != nil {
+				return err
+			}
+			s, err := scan.NewGitScanner(context.Background(), args[0], path, rules, report, true)
+			if err != nil {
+				return fmt.Errorf("failed to initialise scanner for %s: %w", args[0], err)
+			}
+			s.Baseline = b
+			s.ScanCache = openCache(s.Type(), s.Repo.Source, s.RuleSet)
+			s.MergePolicy = mergePolicy
+			s.Mode = mode
+			s.Repo.Since = sinceDate
+			s.Repo.Until = untilDate
+			s.Repo.From = from
+			s.Repo.To = to
+			s.Repo.MaxCommits = maxCommits
+			s.Repo.AllRefs = allRefs
+			s.Repo.RefInclude = includeRefs
+			s.Repo.RefExclude = excludeRefs
+			if _, err := s.Scan(context.Background(), scan.ScanOptions{Concurrent: concurrent}); err != nil {
+				return fmt.Errorf("scan failed: %w", err)
+			}
+			if failOnFindings {
+				return checkFindings(s, threshold)
+			}
+			return nil
+		},
+	}
+
+	flags := cmd.PersistentFlags()
+	flags.StringVarP(&path, "path", "p", ".", "temporary local path to store the git repository (only applies to remote repository)")
+	flags.StringVar(&cacheDir, "cache-dir", "", cacheDirUsage)
+	flags.BoolVar(&noCache, "no-cache", false, noCacheUsage)
+	flags.Int64Var(&cacheSize, "cache-size", scan.DefaultCacheSize>>20, cacheSizeUsage)
+	flags.StringVar(&since, "since", "", "only scan commits more recent than the date (RFC3339 or YYYY-MM-DD)")
+	flags.StringVar(&until, "until", "", "only scan commits older than the date (RFC3339 or YYYY-MM-DD)")
+	flags.StringVar(&from, "from", "", "exclude commits reachable from the revision, also accepts ranges such as main..feature")
+	flags.StringVar(&to, "to", "", "revision from which commits are scanned (defaults to HEAD)")
+	flags.IntVar(&maxCommits, "max-commits", 0, "maximum number of commits to scan (0 for no limit)")
+	flags.StringVar(&mergePolicy, "merge-policy", scan.FirstParent, "how merge commits are diffed against their parents (first-parent, all-parents, combined)")
+	flags.StringVar(&mode, "mode", scan.CommitsMode, "scan the lines added by each commit (commits) or each unique blob once (blobs)")
+	flags.BoolVar(&allRefs, "all-refs", false, "scan every branch, remote-tracking ref and tag instead of --to")
+	flags.StringSliceVar(&includeRefs, "include-refs", nil, "glob patterns of the refs to scan with --all-refs (e.g. 'refs/tags/*', 'release/*')")
+	flags.StringSliceVar(&excludeRefs, "exclude-refs", nil, "glob patterns of the refs to skip with --all-refs")
+	return cmd
 }
 
 func init() {
+	gitScanCmd := newGitScanCmd(newReport, true)
+	gitScanCmd.Flags().StringVar(&baseline, "baseline", "", "path of the baseline of known findings which are not reported")
+	gitScanCmd.Flags().StringVar(&failOn, "fail-on", "", failOnUsage)
 	rootCmd.AddCommand(gitScanCmd)
-	gitScanCmd.PersistentFlags().StringVarP(&path, "path", "p", ".", "temporary local path to store the git repository (only applies to remote repository)")
+}
+
+// parseDate parses a date given as RFC3339 or YYYY-MM-DD
+func parseDate(flag, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --%s %q, must be RFC3339 or YYYY-MM-DD", flag, value)
 }
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/rs/zerolog/log"
+
+	"github.com/spf13/cobra"
+)
+
+var forceHook bool
+
+var hookCmd = &cobra.Command{
+	Use:   "hook",
+	Short: "manage the git hooks running excavator",
+}
+
+var hookInstallCmd = &cobra.Command{
+	Use:   "install [path]",
+	Short: "install a pre-commit hook scanning the staged changes",
+	Long: `Command to write the pre-commit script of the repository (defaults to
+the current directory) which runs excavator protect before each commit.
+The script is written to core.hooksPath if set, .git/hooks otherwise.
+The --rules and --fail-on flags are passed on to the hook.
+
+Commits can still be forced with git commit --no-verify.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setVerbosity()
+		repoPath := "."
+		if len(args) > 0 {
+			repoPath = args[0]
+		}
+
+		hook, err := preCommitHook(repoPath)
+		if err != nil {
+			return fmt.Errorf("failed to locate the git hooks of %s: %w", repoPath, err)
+		}
+		if _, err := os.Stat(hook); err == nil && !forceHook {
+			return fmt.Errorf("a pre-commit hook already exists at %s, use --force to overwrite it", hook)
+		}
+
+		script, err := preCommitScript()
+		if err != nil {
+			return fmt.Errorf("failed to generate the pre-commit hook: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(hook), 0755); err != nil {
+			return fmt.Errorf("failed to create the hooks directory: %w", err)
+		}
+		if err := ioutil.WriteFile(hook, []byte(script), 0755); err != nil {
+			return fmt.Errorf("failed to write the pre-commit hook: %w", err)
+		}
+		log.Info().
+			Str("hook", hook).
+			Msg("Pre-commit hook installed")
+		return nil
+	},
+}
+
+// preCommitHook returns the path of the pre-commit hook of the repository
+// containing the given path. The hooks directory is core.hooksPath when
+// set, the hooks of worktrees and submodules (.git file) being those of
+// the git directory they point to.
+func preCommitHook(repoPath string) (string, error) {
+	repo, err := git.PlainOpenWithOptions(repoPath, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return "", err
+	}
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", errors.New("the repository is not stored on disk")
+	}
+	gitDir := storage.Filesystem().Root()
+	// Linked worktrees share the hooks and config of the main repository
+	if common, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		gitDir = resolvePath(gitDir, strings.TrimSpace(string(common)))
+	}
+
+	hooksPath, err := coreHooksPath(gitDir)
+	if err != nil {
+		return "", err
+	}
+	if hooksPath == "" {
+		return filepath.Join(gitDir, "hooks", "pre-commit"), nil
+	}
+	// Relative hooks paths are relative to the root of the worktree
+	root := gitDir
+	if wt, err := repo.Worktree(); err == nil {
+		root = wt.Filesystem.Root()
+	}
+	if strings.HasPrefix(hooksPath, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		hooksPath = filepath.Join(home, hooksPath[2:])
+	}
+	return filepath.Join(resolvePath(root, hooksPath), "pre-commit"), nil
+}
+
+// coreHooksPath returns the core.hooksPath of the repository config,
+// or of the global config if the repository does not set it
+func coreHooksPath(gitDir string) (string, error) {
+	file, err := os.Open(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	local, err := config.ReadConfig(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the repository config: %w", err)
+	}
+	if hooksPath := local.Raw.Section("core").Option("hooksPath"); hooksPath != "" {
+		return hooksPath, nil
+	}
+	if global, err := config.LoadConfig(config.GlobalScope); err == nil {
+		return global.Raw.Section("core").Option("hooksPath"), nil
+	}
+	return "", nil
+}
+
+// resolvePath returns the path, relative paths being relative to dir
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, path)
+}
+
+// preCommitScript returns the hook running the current executable
+func preCommitScript() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	command := []string{shellQuote(executable), "protect"}
+	if rules != "" {
+		rulesPath, err := filepath.Abs(rules)
+		if err != nil {
+			return "", err
+		}
+		command = append(command, "--rules", shellQuote(rulesPath))
+	}
+	if failOn != "" {
+		command = append(command, "--fail-on", shellQuote(failOn))
+	}
+	return fmt.Sprintf(`#!/bin/sh
+# Installed by excavator hook install, scans the staged changes for leaks
+# (commits can be forced with git commit --no-verify)
+exec %s
+`, strings.Join(command, " ")), nil
+}
+
+// shellQuote quotes the value for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+func init() {
+	hookInstallCmd.Flags().BoolVar(&forceHook, "force", false, "overwrite the existing pre-commit hook")
+	hookInstallCmd.Flags().StringVar(&failOn, "fail-on", "", failOnUsage)
+	hookCmd.AddCommand(hookInstallCmd)
+	rootCmd.AddCommand(hookCmd)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ichbinfrog/excavator/pkg/scan"
+	"github.com/rs/zerolog/log"
+
+	"github.com/spf13/cobra"
+)
+
+var protectCmd = &cobra.Command{
+	Use:   "protect [path]",
+	Short: "scan the changes staged in a git repository",
+	Long: `Command to scan the changes staged in the index of a local git
+repository (defaults to the current directory) before they are committed.
+Only the staged lines which are not in HEAD are reported, findings are
+printed in a compact format (see --format) and exit with a non zero code.
+
+  excavator hook install   # runs excavator protect before each commit`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setVerbosity()
+		repoPath := "."
+		if len(args) > 0 {
+			repoPath = args[0]
+		}
+		if !cmd.Flags().Changed("format") {
+			format = "text"
+		}
+		log.Debug().
+			Str("repo", repoPath).
+			Str("rules", rules).
+			Str("format", format).
+			Str("baseline", baseline).
+			Str("fail_on", failOn).
+			Msg("Scan initiated with configuration")
+
+		threshold, err := parseFailOn()
+		if err != nil {
+			return err
+		}
+		b, err := loadBaseline()
+		if err != nil {
+			return err
+		}
+		report, err := newReport()
+		if err != nil {
+			return err
+		}
+		s, err := scan.NewStagedScanner(filepath.Clean(repoPath), rules, report)
+		if err != nil {
+			return fmt.Errorf("failed to initialise scanner for %s: %w", repoPath, err)
+		}
+		s.Baseline = b
+		if _, err := s.Scan(context.Background(), scan.ScanOptions{Concurrent: concurrent}); err != nil {
+			return fmt.Errorf("scan failed: %w", err)
+		}
+		return checkFindings(s, threshold)
+	},
+}
+
+func init() {
+	protectCmd.Flags().StringVar(&baseline, "baseline", "", "path of the baseline of known findings which are not reported")
+	protectCmd.Flags().StringVar(&failOn, "fail-on", "", failOnUsage)
+	rootCmd.AddCommand(protectCmd)
+}
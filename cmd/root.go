@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"runtime"
 
+	"github.com/ichbinfrog/excavator/pkg/scan"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
@@ -10,19 +13,26 @@ import (
 
 var (
 	path, rules, format string
+	output              string
 	concurrent          int
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "excavator",
 	Short: "small cli to scan a git repository for potential leaks",
+	// Errors are logged by Execute, the usage is only printed for invalid flags
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
 
-// Execute attempts to run the command
+// Execute attempts to run the command and exits with the code
+// matching the error it returned (see exitCode)
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal().Err(err).Msg("Failed to execute command")
+	err := rootCmd.Execute()
+	if exitCode(err) == exitError {
+		log.Error().Err(err).Msg("Failed to execute command")
 	}
+	os.Exit(exitCode(err))
 }
 
 var (
@@ -44,11 +54,21 @@ func setVerbosity() {
 	}
 }
 
+// newReport returns the report writer of the output formats
+func newReport() (scan.ReportInterface, error) {
+	report, err := scan.NewReport(format, output)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output: %w", err)
+	}
+	return report, nil
+}
+
 func init() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	flags := rootCmd.PersistentFlags()
 	flags.CountVarP(&verbosity, "verbosity", "v", "logging verbosity (default : warning)")
 	flags.StringVarP(&rules, "rules", "r", "", "location of the rule declaration (defaults to internal)")
-	flags.StringVarP(&format, "format", "f", "html", "output format of the scan results")
-	flags.IntVarP(&concurrent, "concurrent", "c", 1, "number of concurrent executions (any number below 0 is considered as a single routine execution)")
+	flags.StringVarP(&format, "format", "f", "html", "comma separated output formats of the scan results (html, yaml, json, jsonl, sarif, text)")
+	flags.StringVarP(&output, "output", "o", "", "path of the report, '-' for stdout (base name of the reports if several formats are given)")
+	flags.IntVarP(&concurrent, "concurrent", "c", runtime.NumCPU(), "number of workers analysing the commits or files (any number below 1 uses the number of CPUs)")
 }
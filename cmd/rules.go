@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+	"github.com/ichbinfrog/excavator/pkg/scan"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/spf13/cobra"
+)
+
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "manage the rules definition files",
+}
+
+var rulesMigrateCmd = &cobra.Command{
+	Use:   "migrate <file>",
+	Short: "migrate a rules definition file to the latest schema",
+	Long: `Command to migrate a rules definition file to the latest apiVersion
+(` + model.LatestAPIVersion + `) and to rename the aliased keys (exclude), the migrated rules
+are written to the standard output or to --output. Comments of the file are kept.
+
+  excavator rules migrate rules.yaml -o rules.yaml`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		setVerbosity()
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return fmt.Errorf("failed to read rules definition: %w", err)
+		}
+		doc, version, err := model.MigrateRules(data)
+		if err != nil {
+			return fmt.Errorf("failed to migrate rules %s: %w", args[0], err)
+		}
+		model.SetAPIVersion(doc, model.LatestAPIVersion)
+
+		migrated := &bytes.Buffer{}
+		encoder := yaml.NewEncoder(migrated)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(doc); err != nil {
+			return fmt.Errorf("failed to marshal rules: %w", err)
+		}
+		if output == "" || output == scan.Stdout {
+			os.Stdout.Write(migrated.Bytes())
+		} else if err := ioutil.WriteFile(output, migrated.Bytes(), 0644); err != nil {
+			return fmt.Errorf("failed to write rules: %w", err)
+		}
+		log.Info().
+			Str("from", version).
+			Str("to", model.LatestAPIVersion).
+			Msg("Rules migrated")
+		return nil
+	},
+}
+
+func init() {
+	rulesCmd.AddCommand(rulesMigrateCmd)
+	rootCmd.AddCommand(rulesCmd)
+}
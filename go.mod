@@ -5,14 +5,19 @@ go 1.15
 require (
 	github.com/Masterminds/goutils v1.1.0 // indirect
 	github.com/Masterminds/sprig v2.22.0+incompatible
-	github.com/go-git/go-git/v5 v5.1.0
+	github.com/go-git/go-billy/v5 v5.1.0
+	github.com/go-git/go-git/v5 v5.3.0
 	github.com/gobuffalo/packr/v2 v2.8.0
 	github.com/google/uuid v1.1.2 // indirect
+	github.com/hashicorp/hcl/v2 v2.10.1
 	github.com/huandu/xstrings v1.3.2 // indirect
 	github.com/mholt/archiver/v3 v3.3.2
 	github.com/mitchellh/copystructure v1.0.0 // indirect
 	github.com/rs/zerolog v1.20.0
 	github.com/schollz/progressbar/v3 v3.6.0
+	github.com/sergi/go-diff v1.1.0
 	github.com/spf13/cobra v1.0.0
+	github.com/stretchr/testify v1.6.1
+	github.com/zclconf/go-cty v1.8.0
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
@@ -0,0 +1,85 @@
+// Package gittest provides the in memory repositories used
+// as fixtures by the tests of the git scans
+package gittest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// Repo is an in memory repository with its worktree,
+// failures of the operations fail the test
+type Repo struct {
+	*git.Repository
+	FS       billy.Filesystem
+	Worktree *git.Worktree
+
+	tb testing.TB
+}
+
+// NewRepo initialises an empty in memory repository
+func NewRepo(tb testing.TB) *Repo {
+	tb.Helper()
+	fs := memfs.New()
+	storer, err := git.Init(memory.NewStorage(), fs)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	wt, err := storer.Worktree()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &Repo{Repository: storer, FS: fs, Worktree: wt, tb: tb}
+}
+
+// WriteFile writes the file in the worktree without staging it
+func (r *Repo) WriteFile(file, content string) {
+	r.tb.Helper()
+	if err := util.WriteFile(r.FS, file, []byte(content), 0644); err != nil {
+		r.tb.Fatal(err)
+	}
+}
+
+// Stage writes the file in the worktree and adds it to the index
+func (r *Repo) Stage(file, content string) {
+	r.tb.Helper()
+	r.WriteFile(file, content)
+	if _, err := r.Worktree.Add(file); err != nil {
+		r.tb.Fatal(err)
+	}
+}
+
+// Commit commits the index at the given date, the parents
+// default to the HEAD of the repository
+func (r *Repo) Commit(msg string, when time.Time, parents ...plumbing.Hash) plumbing.Hash {
+	r.tb.Helper()
+	sig := &object.Signature{
+		Name:  "excavator",
+		Email: "excavator@example.com",
+		When:  when,
+	}
+	hash, err := r.Worktree.Commit(msg, &git.CommitOptions{Author: sig, Committer: sig, Parents: parents})
+	if err != nil {
+		r.tb.Fatal(err)
+	}
+	return hash
+}
+
+// Checkout checks the branch out, creating it from HEAD if create
+func (r *Repo) Checkout(branch string, create bool) {
+	r.tb.Helper()
+	if err := r.Worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branch),
+		Create: create,
+	}); err != nil {
+		r.tb.Fatal(err)
+	}
+}
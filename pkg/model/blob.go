@@ -0,0 +1,179 @@
+package model
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/binary"
+)
+
+// BlobOrigin is a path at which a blob has been introduced
+// and the earliest commit which introduced it there
+type BlobOrigin struct {
+	Path   string
+	Commit plumbing.Hash
+	When   time.Time
+	// Blob at the path in the first parent of the commit,
+	// zero if the commit created the file
+	Parent plumbing.Hash
+}
+
+// BlobIndex maps each unique blob introduced by the walked
+// commits to the paths at which it has been introduced
+type BlobIndex struct {
+	// Blobs sorted by the date they were first introduced
+	Blobs []plumbing.Hash
+	// Origins of each blob sorted by date
+	Origins map[plumbing.Hash][]BlobOrigin
+}
+
+// IndexBlobs walks the commits (see WalkCommits) and records for each blob
+// the earliest commit which introduced it at each path.
+//
+// A blob is introduced by a commit when none of its parents has it at the
+// same path, blobs brought by a merge are therefore attributed to the
+// commits of the merged branch and reverts to the original commit.
+// Symlinks and submodules are not indexed.
+//
+// Only the lines a blob adds to its parent blob are reported (see ParseBlob)
+// so that the versions of a file do not report the same leaks again.
+//
+func (r *Repo) IndexBlobs(ctx context.Context) (*BlobIndex, error) {
+	index := &BlobIndex{Origins: map[plumbing.Hash][]BlobOrigin{}}
+	err := r.WalkCommits(func(commit *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return index.add(commit)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for hash, origins := range index.Origins {
+		sort.Slice(origins, func(i, j int) bool {
+			if !origins[i].When.Equal(origins[j].When) {
+				return origins[i].When.Before(origins[j].When)
+			}
+			return origins[i].Path < origins[j].Path
+		})
+		index.Blobs = append(index.Blobs, hash)
+	}
+	sort.Slice(index.Blobs, func(i, j int) bool {
+		wi, wj := index.Origins[index.Blobs[i]][0].When, index.Origins[index.Blobs[j]][0].When
+		if !wi.Equal(wj) {
+			return wi.Before(wj)
+		}
+		return index.Blobs[i].String() < index.Blobs[j].String()
+	})
+	return index, nil
+}
+
+// add records the blobs introduced by the commit
+func (b *BlobIndex) add(commit *object.Commit) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	parents := []*object.Tree{}
+	if err := commit.Parents().ForEach(func(parent *object.Commit) error {
+		parentTree, err := parent.Tree()
+		if err != nil {
+			return err
+		}
+		parents = append(parents, parentTree)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	// Changes are computed against the first parent, the blobs
+	// also found in the other parents are not introduced by the merge
+	var from *object.Tree
+	var others []*object.Tree
+	if len(parents) > 0 {
+		from, others = parents[0], parents[1:]
+	}
+	changes, err := object.DiffTree(from, tree)
+	if err != nil {
+		return err
+	}
+	for _, ch := range changes {
+		entry := ch.To
+		if entry.Name == "" || !entry.TreeEntry.Mode.IsFile() || entry.TreeEntry.Mode == filemode.Symlink {
+			continue
+		}
+		if inParents(others, entry.Name, entry.TreeEntry.Hash) {
+			continue
+		}
+		origin := BlobOrigin{
+			Path:   entry.Name,
+			Commit: commit.Hash,
+			When:   commit.Committer.When,
+		}
+		if ch.From.Name != "" && ch.From.TreeEntry.Mode.IsFile() && ch.From.TreeEntry.Mode != filemode.Symlink {
+			origin.Parent = ch.From.TreeEntry.Hash
+		}
+		b.record(entry.TreeEntry.Hash, origin)
+	}
+	return nil
+}
+
+// record keeps the origin if the blob has not been introduced
+// at the same path by an earlier commit
+func (b *BlobIndex) record(hash plumbing.Hash, origin BlobOrigin) {
+	origins := b.Origins[hash]
+	for idx := range origins {
+		if origins[idx].Path != origin.Path {
+			continue
+		}
+		// Commits are walked from the most recent ones
+		if !origin.When.After(origins[idx].When) {
+			origins[idx] = origin
+		}
+		return
+	}
+	b.Origins[hash] = append(origins, origin)
+}
+
+// inParents checks whether or not one of the trees has the blob at the path
+func inParents(parents []*object.Tree, path string, hash plumbing.Hash) bool {
+	for _, parent := range parents {
+		if entry, err := parent.FindEntry(path); err == nil && entry.Hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseBlob applies all rules to the lines the blob adds to the parent
+// blob of its origin (the whole content if the file was created) and sends
+// the leaks as GitLeaks of the origin commit, binary blobs are skipped.
+func (r *RuleSet) ParseBlob(hash plumbing.Hash, origin BlobOrigin, commit *object.Commit, repo *Repo, leakChan chan Leak) error {
+	if r.isBlackListed(origin.Path) {
+		return nil
+	}
+	content, err := blobContent(repo, hash)
+	if err != nil {
+		return err
+	}
+	if isBinary, _ := binary.IsBinary(strings.NewReader(content)); isBinary {
+		return nil
+	}
+	before := ""
+	if !origin.Parent.IsZero() {
+		if before, err = blobContent(repo, origin.Parent); err != nil {
+			return err
+		}
+	}
+	lines, added := diffLines(before, content)
+	r.parseLines(origin.Path, lines, added, func(found FileLeak) {
+		leakChan <- newGitLeak(found, commit, repo, hash, origin.Path)
+	})
+	return nil
+}
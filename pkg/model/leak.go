@@ -1,7 +1,13 @@
 package model
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
 	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 )
 
 // Leak is an interface that represents a possible credential leak
@@ -9,10 +15,112 @@ import (
 type Leak interface {
 }
 
+// Confidences sorted by increasing certainty
+var Confidences = []string{"Entropy", "Low", "Medium", "High"}
+
+// confidenceWeights are the weights of the findings
+// of rules which do not define their own weight
+var confidenceWeights = map[string]float32{
+	"Entropy": 0.1,
+	"Low":     0.3,
+	"Medium":  0.5,
+	"High":    1,
+}
+
+// ConfidenceRank returns the index of the confidence in Confidences
+// or -1 if the confidence is unknown
+func ConfidenceRank(confidence string) int {
+	for idx, c := range Confidences {
+		if strings.EqualFold(c, confidence) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// weight returns the weight of the regex rule if it defines one
+// and the weight associated to the confidence otherwise
+func weight(indep *IndepParserRule, confidence string) float32 {
+	if indep != nil && indep.Weight > 0 {
+		return indep.Weight
+	}
+	return confidenceWeights[confidence]
+}
+
+// RuleInfo is the description of a rule shared by all kinds of rules
+// (regex, parser and entropy) which is used when writing reports
+type RuleInfo struct {
+	// Identifier of the rule, stable across scans
+	ID          string
+	Description string
+	Category    string
+}
+
+// ruleInfo returns the description of the rule which detected the leak
+// (only one of the rules is set)
+func ruleInfo(indep *IndepParserRule, ctx *CtxParserRule, entropy *EntropyRule) RuleInfo {
+	switch {
+	case indep != nil:
+		return indep.Info()
+	case ctx != nil:
+		return ctx.Info()
+	case entropy != nil:
+		return entropy.Info()
+	}
+	return RuleInfo{}
+}
+
+// endAffected returns the index in the snippet of the last line of a leak
+// ending on endLine (zero for the leaks ending on their first line)
+func endAffected(line, endLine, affected int) int {
+	if endLine <= line {
+		return affected
+	}
+	return affected + endLine - line
+}
+
+// match returns the offending part of the snippet from the start index
+// of the affected line to the end index of the last line of the leak
+func match(snippet []string, affected, last, start, end int) string {
+	if affected < 0 || last < affected || last >= len(snippet) {
+		return ""
+	}
+	value := strings.Join(snippet[affected:last+1], "\n")
+	end += len(value) - len(snippet[last])
+	if start < 0 || end > len(value) || start > end {
+		return ""
+	}
+	return value[start:end]
+}
+
+// normalizeMatch trims the spaces and quotes surrounding the offending value
+func normalizeMatch(value string) string {
+	return strings.Trim(strings.TrimSpace(value), "\"'`")
+}
+
+// matchHash returns the hash of the normalized offending value
+func matchHash(value string) string {
+	sum := sha1.Sum([]byte(normalizeMatch(value)))
+	return hex.EncodeToString(sum[:])
+}
+
+// fingerprint identifies a leak by its rule, file and offending value
+// so that the same secret has the same fingerprint whatever its line
+func fingerprint(rule, file, value string) string {
+	sum := sha1.Sum([]byte(rule + "\x00" + file + "\x00" + normalizeMatch(value)))
+	return hex.EncodeToString(sum[:])
+}
+
 // GitLeak is a potential leak detected associated with a commit
 type GitLeak struct {
 	// Hash of the commit (SHA-1)
 	Commit string `yaml:"commit"`
+	// Hashes of the parents of the commit
+	Parents []string `yaml:"parents,omitempty"`
+	// Subject of the commit (first line of the message)
+	Subject string `yaml:"subject"`
+	// Hash of the blob of the affected file after the commit
+	Blob string `yaml:"blob"`
 	// File (path to the file from the root of the git repo)
 	File string `yaml:"file"`
 	// Line number within the affected file
@@ -26,22 +134,72 @@ type GitLeak struct {
 	Affected int `yaml:"affected"`
 	// Start index of the offending snippet within the affected line
 	StartIdx int
-	// End index of the offending snippet within the affected line
+	// Line number of the end of the offending snippet
+	// (only set by multi-line rules, Line otherwise)
+	EndLine int `yaml:"end_line,omitempty"`
+	// End index of the offending snippet within its last line
 	EndIdx     int
 	Confidence string `yaml:"confidence"`
+	// Shannon entropy of the offending snippet (only set by entropy rules)
+	Entropy float64 `yaml:"entropy,omitempty"`
+	// Flattened key of the offending value (only set by structured parsers)
+	Key string `yaml:"key,omitempty"`
 
-	// Stores the name of the author of the commit
-	// This could be replaced with the email for better formatting
-	Author string `yaml:"author,omitempty"`
+	// Name and email of the author of the commit
+	Author      string `yaml:"author,omitempty"`
+	AuthorEmail string `yaml:"author_email,omitempty"`
+	// Name and email of the committer of the commit
+	Committer      string `yaml:"committer,omitempty"`
+	CommitterEmail string `yaml:"committer_email,omitempty"`
 	// Time of the commit
 	When time.Time `yaml:"commit_date"`
+	// Names of the refs (branches, tags) containing the commit
+	// only set when scanning all refs
+	Refs []string `yaml:"refs,omitempty"`
 
 	// Pointer to the offending rule
-	IndepParserRule *IndepParserRule `yaml:"-"`
+	IndepParserRule *IndepParserRule `yaml:"-" json:"-"`
 	// Pointer to the offending parser rule
-	// The Rule and ParserRule attributes are exclusive
-	CtxParserRule *CtxParserRule `yaml:"-"`
-	Repo          *Repo          `yaml:"-"`
+	// The Rule, ParserRule and EntropyRule attributes are exclusive
+	CtxParserRule *CtxParserRule `yaml:"-" json:"-"`
+	// Pointer to the offending entropy rule
+	EntropyRule *EntropyRule `yaml:"-" json:"-"`
+	Repo        *Repo        `yaml:"-" json:"-"`
+}
+
+// newGitLeak converts the leak found in the blob at the path after the
+// commit into a leak filled with the metadata of the commit and of the blob
+func newGitLeak(found FileLeak, commit *object.Commit, repo *Repo, blob plumbing.Hash, path string) GitLeak {
+	parents := make([]string, len(commit.ParentHashes))
+	for idx, parent := range commit.ParentHashes {
+		parents[idx] = parent.String()
+	}
+	return GitLeak{
+		Commit:          commit.Hash.String(),
+		Parents:         parents,
+		Subject:         strings.TrimSpace(strings.SplitN(commit.Message, "\n", 2)[0]),
+		Blob:            blob.String(),
+		File:            path,
+		Line:            found.Line,
+		Snippet:         found.Snippet,
+		Affected:        found.Affected,
+		StartIdx:        found.StartIdx,
+		EndLine:         found.EndLine,
+		EndIdx:          found.EndIdx,
+		Confidence:      found.Confidence,
+		Entropy:         found.Entropy,
+		Key:             found.Key,
+		Author:          commit.Author.Name,
+		AuthorEmail:     commit.Author.Email,
+		Committer:       commit.Committer.Name,
+		CommitterEmail:  commit.Committer.Email,
+		When:            commit.Author.When,
+		Refs:            repo.Refs(commit.Hash),
+		IndepParserRule: found.IndepParserRule,
+		CtxParserRule:   found.CtxParserRule,
+		EntropyRule:     found.EntropyRule,
+		Repo:            repo,
+	}
 }
 
 // FileLeak is a potential leak detected in the filesystem
@@ -58,10 +216,82 @@ type FileLeak struct {
 	Affected int `yaml:"affected"`
 	// Start index of the offending snippet within the affected line
 	StartIdx int
-	// End index of the offending snippet within the affected line
+	// Line number of the end of the offending snippet
+	// (only set by multi-line rules, Line otherwise)
+	EndLine int `yaml:"end_line,omitempty"`
+	// End index of the offending snippet within its last line
 	EndIdx     int
 	Confidence string `yaml:"confidence"`
+	// Shannon entropy of the offending snippet (only set by entropy rules)
+	Entropy float64 `yaml:"entropy,omitempty"`
+	// Flattened key of the offending value (only set by structured parsers)
+	Key string `yaml:"key,omitempty"`
+
+	IndepParserRule *IndepParserRule `yaml:"-" json:"-"`
+	CtxParserRule   *CtxParserRule   `yaml:"-" json:"-"`
+	EntropyRule     *EntropyRule     `yaml:"-" json:"-"`
+}
+
+// Rule returns the description of the rule which detected the leak
+func (g GitLeak) Rule() RuleInfo {
+	return ruleInfo(g.IndepParserRule, g.CtxParserRule, g.EntropyRule)
+}
+
+// Match returns the offending part of the affected line
+// (of the affected lines for multi-line leaks)
+func (g GitLeak) Match() string {
+	return match(g.Snippet, g.Affected, g.EndAffected(), g.StartIdx, g.EndIdx)
+}
+
+// EndAffected returns the index of the last offending line in the snippet
+func (g GitLeak) EndAffected() int {
+	return endAffected(g.Line, g.EndLine, g.Affected)
+}
+
+// MatchHash returns the hash of the normalized offending value
+func (g GitLeak) MatchHash() string {
+	return matchHash(g.Match())
+}
+
+// Weight returns the weight of the rule or of the confidence of the leak
+func (g GitLeak) Weight() float32 {
+	return weight(g.IndepParserRule, g.Confidence)
+}
+
+// Fingerprint returns the hash of the rule, file and offending value
+// which does not depend on the position of the leak nor on the commit
+func (g GitLeak) Fingerprint() string {
+	return fingerprint(g.Rule().ID, g.File, g.Match())
+}
+
+// Rule returns the description of the rule which detected the leak
+func (f FileLeak) Rule() RuleInfo {
+	return ruleInfo(f.IndepParserRule, f.CtxParserRule, f.EntropyRule)
+}
+
+// Match returns the offending part of the affected line
+// (of the affected lines for multi-line leaks)
+func (f FileLeak) Match() string {
+	return match(f.Snippet, f.Affected, f.EndAffected(), f.StartIdx, f.EndIdx)
+}
+
+// EndAffected returns the index of the last offending line in the snippet
+func (f FileLeak) EndAffected() int {
+	return endAffected(f.Line, f.EndLine, f.Affected)
+}
+
+// MatchHash returns the hash of the normalized offending value
+func (f FileLeak) MatchHash() string {
+	return matchHash(f.Match())
+}
+
+// Weight returns the weight of the rule or of the confidence of the leak
+func (f FileLeak) Weight() float32 {
+	return weight(f.IndepParserRule, f.Confidence)
+}
 
-	IndepParserRule *IndepParserRule `yaml:"-"`
-	CtxParserRule   *CtxParserRule   `yaml:"-"`
+// Fingerprint returns the hash of the rule, file and offending value
+// which does not depend on the position of the leak
+func (f FileLeak) Fingerprint() string {
+	return fingerprint(f.Rule().ID, f.File, f.Match())
 }
@@ -1,9 +1,8 @@
 package model
 
 import (
+	"fmt"
 	"io"
-
-	"github.com/rs/zerolog/log"
 )
 
 // contextParser is an interface to parsers
@@ -32,7 +31,7 @@ type CtxParserRule struct {
 }
 
 // Init creates a Parser if the .Type is defined
-func (c *CtxParserRule) Init() {
+func (c *CtxParserRule) Init() error {
 	if c.KeyBag == nil {
 		c.KeyBag = []string{
 			"pass",
@@ -64,9 +63,20 @@ func (c *CtxParserRule) Init() {
 	case "xml":
 		c.Parser = newXMLParser(&c.KeyBag)
 		break
+	case "hcl", "terraform":
+		c.Parser = newHCLParser(&c.KeyBag)
+		break
 	default:
-		log.Fatal().
-			Str("parser_type", c.Type).
-			Msg("Unknown parser type, must be (env, dockerfile)")
+		return fmt.Errorf("unknown parser type %q, must be (env, dockerfile, properties, shell, json, yaml, xml, hcl)", c.Type)
+	}
+	return nil
+}
+
+// Info returns the description of the rule used in reports
+func (c *CtxParserRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          "parser/" + c.Type,
+		Description: "sensitive key assigned in a " + c.Type + " file",
+		Category:    "parser",
 	}
 }
@@ -0,0 +1,135 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	base64Charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/="
+	hexCharset    = "0123456789abcdefABCDEF"
+)
+
+// EntropyRule represents a context independant detector
+// which flags random looking tokens (generated passwords, internal api keys, ...)
+// that have no known prefix and thus can not be described by a regex.
+//
+// A line is split into tokens made of the characters of the charset,
+// each token longer than MinLength has its Shannon entropy computed
+// and is considered as a potential leak if it exceeds the Threshold.
+type EntropyRule struct {
+	// Charset of the tokens to analyse ("base64" or "hex")
+	Charset string `yaml:"charset"`
+	// Minimum length of a token for it to be analysed
+	MinLength int `yaml:"min_length"`
+	// Entropy (in bits per character) above which a token is
+	// considered as a potential leak
+	Threshold   float64 `yaml:"threshold"`
+	Description string  `yaml:"description,omitempty"`
+	Category    string  `yaml:"category,omitempty"`
+	// Confidence of the assessment (defaults to "Entropy")
+	// which distinguishes entropy findings from the regex / parser ones
+	Confidence string `yaml:"confidence"`
+
+	alphabet string
+}
+
+// Info returns the description of the rule used in reports
+func (e *EntropyRule) Info() RuleInfo {
+	return RuleInfo{
+		ID:          "entropy/" + e.Charset,
+		Description: e.Description,
+		Category:    e.Category,
+	}
+}
+
+// entropyMatch is a token of a line which exceeds the entropy threshold
+type entropyMatch struct {
+	start, end int
+	entropy    float64
+}
+
+// Init sets the default values of the rule according to its charset
+func (e *EntropyRule) Init() error {
+	switch e.Charset {
+	case "base64":
+		e.alphabet = base64Charset
+		if e.Threshold == 0 {
+			// A token of 20 characters has at most log2(20) ~ 4.32 bits
+			e.Threshold = 4.0
+		}
+		if e.MinLength == 0 {
+			e.MinLength = 20
+		}
+		break
+	case "hex":
+		e.alphabet = hexCharset
+		if e.Threshold == 0 {
+			e.Threshold = 3.0
+		}
+		if e.MinLength == 0 {
+			e.MinLength = 32
+		}
+		break
+	default:
+		return fmt.Errorf("unknown entropy charset %q, must be (base64, hex)", e.Charset)
+	}
+
+	if e.Confidence == "" {
+		e.Confidence = "Entropy"
+	}
+	if e.Category == "" {
+		e.Category = "entropy"
+	}
+	if e.Description == "" {
+		e.Description = "high entropy " + e.Charset + " string"
+	}
+	return nil
+}
+
+// Find returns all tokens of the line which are at least MinLength long
+// and whose entropy is above the Threshold
+func (e *EntropyRule) Find(line string) []entropyMatch {
+	matches := []entropyMatch{}
+	start := -1
+	for idx := 0; idx <= len(line); idx++ {
+		if idx < len(line) && strings.IndexByte(e.alphabet, line[idx]) != -1 {
+			if start == -1 {
+				start = idx
+			}
+			continue
+		}
+		if start != -1 && idx-start >= e.MinLength {
+			if entropy := shannonEntropy(line[start:idx]); entropy >= e.Threshold {
+				matches = append(matches, entropyMatch{
+					start:   start,
+					end:     idx,
+					entropy: entropy,
+				})
+			}
+		}
+		start = -1
+	}
+	return matches
+}
+
+// shannonEntropy computes the entropy of a token
+// in bits per character
+func shannonEntropy(token string) float64 {
+	if len(token) == 0 {
+		return 0
+	}
+	freq := map[byte]int{}
+	for i := 0; i < len(token); i++ {
+		freq[token[i]]++
+	}
+
+	entropy := 0.0
+	length := float64(len(token))
+	for _, count := range freq {
+		p := float64(count) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
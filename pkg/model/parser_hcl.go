@@ -0,0 +1,188 @@
+package model
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/rs/zerolog/log"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// hclParser is the parser for HashiCorp Configuration Language files
+// (terraform .tf, .tfvars, ...).
+//
+// The parser walks the syntax tree of the file and flattens blocks and
+// attributes into a list of keys built by appending the block type, its
+// labels and the attribute name:
+//
+//	resource "aws_db_instance" "default" {
+//		password = "foobarbaz"
+//	}
+//
+// results in "resource.aws_db_instance.default.password" : "foobarbaz"
+//
+// Values that are literal strings are considered as hardcoded secrets ("High")
+// whereas values refering to other objects (var., data., local., ...),
+// interpolating them or calling functions are downgraded ("Medium").
+// Numbers, booleans and null are never reported.
+type hclParser struct {
+	keyBag *[]string
+}
+
+// hclValue is a flattened value of the file
+type hclValue struct {
+	key  string
+	expr hclsyntax.Expression
+	// whether or not the value is a literal string
+	literal bool
+}
+
+func newHCLParser(keyBag *[]string) *hclParser {
+	return &hclParser{
+		keyBag: keyBag,
+	}
+}
+
+// Parse flattens the hcl file and reports values which attribute
+// name contains one of the words of the key bag
+func (h *hclParser) Parse(reader io.Reader, leakChan chan Leak, file string, rule *CtxParserRule) {
+	buf := &bytes.Buffer{}
+	buf.ReadFrom(reader)
+	data := buf.Bytes()
+
+	parsed, diags := hclsyntax.ParseConfig(data, file, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		// Values found before the syntax error are still reported
+		log.Trace().
+			Err(diags).
+			Str("file", file).
+			Msg("Failed to parse")
+	}
+	body, ok := parsed.Body.(*hclsyntax.Body)
+	if !ok {
+		return
+	}
+	values := []hclValue{}
+	visitHCLBody("", body, &values)
+
+	index := newLineIndex(data)
+	lines := strings.Split(buf.String(), "\n")
+	for _, v := range values {
+		name := v.key
+		if lastIndex := strings.LastIndex(name, "."); lastIndex != -1 {
+			name = name[lastIndex+1:]
+		}
+		for _, key := range *h.keyBag {
+			if !strings.Contains(strings.ToLower(name), key) {
+				continue
+			}
+			line, startIdx, endLine, endIdx := hclBounds(v.expr, data, index)
+			start, _ := snippetBounds(line-1, len(lines))
+			_, end := snippetBounds(endLine-1, len(lines))
+			confidence := "High"
+			if !v.literal {
+				confidence = "Medium"
+			}
+			disc := FileLeak{
+				File:          file,
+				Line:          line,
+				Affected:      line - 1 - start,
+				StartIdx:      startIdx,
+				EndIdx:        endIdx,
+				Key:           v.key,
+				CtxParserRule: rule,
+				Confidence:    confidence,
+			}
+			if endLine != line {
+				disc.EndLine = endLine
+			}
+			disc.Snippet = make([]string, len(lines[start:end]))
+			copy(disc.Snippet, lines[start:end])
+			leakChan <- disc
+			break
+		}
+	}
+}
+
+// visitHCLBody flattens the attributes and the nested blocks of the
+// body in the order of the file
+func visitHCLBody(prefix string, body *hclsyntax.Body, res *[]hclValue) {
+	attributes := make([]*hclsyntax.Attribute, 0, len(body.Attributes))
+	for _, attr := range body.Attributes {
+		attributes = append(attributes, attr)
+	}
+	sort.Slice(attributes, func(i, j int) bool {
+		return attributes[i].SrcRange.Start.Byte < attributes[j].SrcRange.Start.Byte
+	})
+
+	attrIdx := 0
+	for _, block := range body.Blocks {
+		for ; attrIdx < len(attributes) && attributes[attrIdx].SrcRange.Start.Byte < block.TypeRange.Start.Byte; attrIdx++ {
+			visitHCLExpr(joinHCLKey(prefix, attributes[attrIdx].Name), attributes[attrIdx].Expr, res)
+		}
+		key := joinHCLKey(prefix, block.Type)
+		for _, label := range block.Labels {
+			key = joinHCLKey(key, label)
+		}
+		visitHCLBody(key, block.Body, res)
+	}
+	for ; attrIdx < len(attributes); attrIdx++ {
+		visitHCLExpr(joinHCLKey(prefix, attributes[attrIdx].Name), attributes[attrIdx].Expr, res)
+	}
+}
+
+// visitHCLExpr flattens the elements of objects and tuples,
+// other expressions are values of the key
+func visitHCLExpr(key string, expr hclsyntax.Expression, res *[]hclValue) {
+	switch e := expr.(type) {
+	case *hclsyntax.ObjectConsExpr:
+		for _, item := range e.Items {
+			name := hcl.ExprAsKeyword(item.KeyExpr)
+			if name == "" {
+				value, diags := item.KeyExpr.Value(nil)
+				if diags.HasErrors() || !value.IsKnown() || value.IsNull() || value.Type() != cty.String {
+					continue
+				}
+				name = value.AsString()
+			}
+			visitHCLExpr(joinHCLKey(key, name), item.ValueExpr, res)
+		}
+	case *hclsyntax.TupleConsExpr:
+		for _, elem := range e.Exprs {
+			visitHCLExpr(key, elem, res)
+		}
+	case *hclsyntax.LiteralValueExpr:
+		// Numbers, booleans and null can not be secrets
+		if e.Val.Type() == cty.String {
+			*res = append(*res, hclValue{key: key, expr: e, literal: true})
+		}
+	case *hclsyntax.TemplateExpr:
+		*res = append(*res, hclValue{key: key, expr: e, literal: e.IsStringLiteral()})
+	default:
+		*res = append(*res, hclValue{key: key, expr: e})
+	}
+}
+
+func joinHCLKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// hclBounds returns the line (starting from 1) and byte column of the start
+// and of the end of the expression, the quotes of strings are excluded
+func hclBounds(expr hclsyntax.Expression, data []byte, index *lineIndex) (int, int, int, int) {
+	rng := expr.Range()
+	start, end := rng.Start.Byte, rng.End.Byte
+	if end-start >= 2 && data[start] == '"' && data[end-1] == '"' {
+		start, end = start+1, end-1
+	}
+	line, startIdx := index.position(start)
+	endLine, endIdx := index.position(end)
+	return line, startIdx, endLine, endIdx
+}
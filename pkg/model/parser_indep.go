@@ -1,6 +1,11 @@
 package model
 
-import "regexp"
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
 
 // IndepParserRule represents a context independant parser
 type IndepParserRule struct {
@@ -8,5 +13,36 @@ type IndepParserRule struct {
 	Description string  `yaml:"description,omitempty"`
 	Category    string  `yaml:"category,omitempty"`
 	Weight      float32 `yaml:"weight"`
-	Compiled    *regexp.Regexp
+	// Apply the regex to the whole content of the file (or hunk)
+	// instead of each line so that matches can span multiple lines
+	Multiline bool `yaml:"multiline,omitempty"`
+	Compiled  *regexp.Regexp
+}
+
+var slugReplacer = regexp.MustCompile("[^a-z0-9]+")
+
+// Info returns the description of the rule used in reports
+//
+// The identifier is built from the category and the description
+// (e.g. "token/amazon-token") or from the hash of the definition
+// if the rule has no description
+func (i *IndepParserRule) Info() RuleInfo {
+	category := i.Category
+	if category == "" {
+		category = "regex"
+	}
+	name := strings.Trim(slugReplacer.ReplaceAllString(strings.ToLower(i.Description), "-"), "-")
+	if name == "" {
+		sum := sha1.Sum([]byte(i.Definition))
+		name = hex.EncodeToString(sum[:])[:8]
+	}
+	description := i.Description
+	if description == "" {
+		description = "match of " + i.Definition
+	}
+	return RuleInfo{
+		ID:          category + "/" + name,
+		Description: description,
+		Category:    category,
+	}
 }
@@ -6,9 +6,10 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
@@ -17,121 +18,518 @@ import (
 // sgmlParser is the base parser for Standard Generalized Markup Language (SGML)
 // derived languages (json, yaml, xml, ...).
 //
-// The SGML Parser attempts to flatten the data structure into a key value map
+// The SGML Parser attempts to flatten the data structure into a list of key values
 // according to a certain set of rules
 //  - the key is built by appending the name of each of the parent node
 //  - for arrays the name of the parent node is it's index in the file
 //    NOTE that arrays are not ordering in this standard, thus the index
 //    is not consistent and only serves to build an unique key
 //
-// In the end, we end up with a map as such
+// In the end, we end up with a list as such
 // "spec.template.spec.containers[0].name" :  "nginx"
 //
-// TODO: Implement the yaml node visitor to store the line number
-//			 as well as to run the key building iteratively for performance
-//
+// Each value keeps track of its position in the original file (line and
+// byte columns) so that the leak can be displayed with its real context.
 //
 type sgmlParser struct {
-	keyBag     *[]string
-	keyMatcher *regexp.Regexp
-	// function that unmarshals the data read from the file into
-	// either a map[string]interface{} or a []interface{} as an
-	// internal representation
-	unmarshal func([]byte, interface{}) error
+	keyBag *[]string
+	// function that walks the data read from the file
+	// and returns all the flattened scalar values
+	flatten func([]byte) ([]sgmlValue, error)
+}
+
+// sgmlValue is a flattened scalar value and its position in the file
+type sgmlValue struct {
+	key string
+	// line of the value in the file (starting from 1)
+	line int
+	// byte columns [start, end) of the value within the line
+	start int
+	end   int
+	// raw representation of the value
+	value string
 }
 
-func newSGMLParser(keyBag *[]string, unmarshaller func([]byte, interface{}) error) *sgmlParser {
+func newSGMLParser(keyBag *[]string, flatten func([]byte) ([]sgmlValue, error)) *sgmlParser {
 	return &sgmlParser{
-		keyBag:     keyBag,
-		keyMatcher: regexp.MustCompile(`"([^\t\n]*)"\s*:`),
-		unmarshal:  unmarshaller,
+		keyBag:  keyBag,
+		flatten: flatten,
 	}
 }
 
+// Parse flattens the file and reports values which key
+// contains one of the words of the key bag
 func (s *sgmlParser) Parse(reader io.Reader, leakChan chan Leak, file string, rule *CtxParserRule) {
 	buf := &bytes.Buffer{}
 	buf.ReadFrom(reader)
-	var data interface{}
 
-	if err := s.unmarshal(buf.Bytes(), &data); err != nil {
+	values, err := s.flatten(buf.Bytes())
+	if err != nil {
+		// Values found before the syntax error are still reported
 		log.Trace().
 			Err(err).
 			Str("file", file).
 			Msg("Failed to unmarshal")
-		return
 	}
 
-	flattened := map[string]string{}
-	switch root := data.(type) {
-	// !!map document node type
-	case map[string]interface{}:
-		for k, v := range root {
-			flattenMap(k, v, flattened)
+	lines := strings.Split(buf.String(), "\n")
+	for _, v := range values {
+		// Only the name of the value (last element, attribute)
+		// is compared to the key bag
+		last := v.key
+		lastIndex := strings.LastIndexAny(v.key, ".@")
+		if lastIndex != -1 {
+			last = v.key[lastIndex:]
 		}
-		break
-	// !!seq document node type
-	case []interface{}:
-		for k, v := range root {
-			flattenMap(strconv.Itoa(k), v, flattened)
+		for _, key := range *s.keyBag {
+			if !strings.Contains(strings.ToLower(last), key) {
+				continue
+			}
+
+			start, end := snippetBounds(v.line-1, len(lines))
+			disc := FileLeak{
+				File:          file,
+				Line:          v.line,
+				Affected:      v.line - 1 - start,
+				StartIdx:      v.start,
+				EndIdx:        v.end,
+				Key:           v.key,
+				CtxParserRule: rule,
+				Confidence:    "High",
+			}
+			disc.Snippet = make([]string, len(lines[start:end]))
+			copy(disc.Snippet, lines[start:end])
+			leakChan <- disc
+			break
 		}
-		break
 	}
+}
 
-	for k, v := range flattened {
-		for _, key := range *s.keyBag {
-			last := k
-			lastIndex := strings.LastIndex(k, ".")
-			if lastIndex != -1 {
-				last = k[lastIndex:]
-			}
-			if strings.Contains(
-				strings.ToLower(last),
-				key,
-			) {
-				leakChan <- FileLeak{
-					File:          file,
-					Line:          0,
-					Snippet:       []string{k + ":" + v},
-					Affected:      0,
-					StartIdx:      len(k) + 1,
-					EndIdx:        0,
-					CtxParserRule: rule,
-					Confidence:    "High",
+// lineIndex converts byte offsets of a file into line / column positions
+type lineIndex struct {
+	data []byte
+	// byte offset of the beginning of each line
+	starts []int
+}
+
+func newLineIndex(data []byte) *lineIndex {
+	l := &lineIndex{
+		data:   data,
+		starts: []int{0},
+	}
+	for idx, c := range data {
+		if c == '\n' {
+			l.starts = append(l.starts, idx+1)
+		}
+	}
+	return l
+}
+
+// position returns the line (starting from 1) and the byte column
+// within this line of the given offset
+func (l *lineIndex) position(offset int) (int, int) {
+	line := sort.Search(len(l.starts), func(i int) bool {
+		return l.starts[i] > offset
+	})
+	return line, offset - l.starts[line-1]
+}
+
+// lineLength returns the length in bytes of the line (starting from 1)
+func (l *lineIndex) lineLength(line int) int {
+	if line < len(l.starts) {
+		return l.starts[line] - l.starts[line-1] - 1
+	}
+	return len(l.data) - l.starts[line-1]
+}
+
+// flattenYAML walks the node tree of each document of the yaml stream
+func flattenYAML(data []byte) ([]sgmlValue, error) {
+	lines := strings.Split(string(data), "\n")
+	values := []sgmlValue{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				return values, nil
+			}
+			return values, err
+		}
+		visitYAML("", &doc, lines, &values)
+	}
+}
+
+func visitYAML(prefix string, node *yaml.Node, lines []string, res *[]sgmlValue) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			visitYAML(prefix, child, lines, res)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			visitYAML(key, node.Content[i+1], lines, res)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			visitYAML(fmt.Sprintf("%s[%d]", prefix, i), child, lines, res)
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" || len(node.Value) == 0 || node.Line > len(lines) {
+			return
+		}
+		line := lines[node.Line-1]
+		start := runeToByteIndex(line, node.Column-1)
+		end := len(line)
+		switch node.Style {
+		case yaml.DoubleQuotedStyle, yaml.SingleQuotedStyle:
+			start++
+			if closing := closingQuote(line, start, line[start-1]); closing != -1 {
+				end = closing
+			}
+		case yaml.LiteralStyle, yaml.FoldedStyle:
+			// block scalars start on the line of their indicator
+		default:
+			if strings.HasPrefix(line[start:], node.Value) {
+				end = start + len(node.Value)
+			}
+		}
+		*res = append(*res, sgmlValue{
+			key:   prefix,
+			line:  node.Line,
+			start: start,
+			end:   end,
+			value: node.Value,
+		})
+	}
+}
+
+// flattenJSON reads the json tokens one by one to keep track
+// of the offset of each value in the file
+func flattenJSON(data []byte) ([]sgmlValue, error) {
+	// frame is a container (object or array) being read
+	type frame struct {
+		object bool
+		path   string
+		// current key of the object
+		key string
+		// index of the next element of the array
+		index int
+		// whether the next token of the object is a key
+		expectKey bool
+	}
+
+	idx := newLineIndex(data)
+	values := []sgmlValue{}
+	// the root frame is neither an object nor an array
+	stack := []*frame{{index: -1}}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return values, nil
+			}
+			return values, err
+		}
+
+		top := stack[len(stack)-1]
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1].expectKey = stack[len(stack)-1].object
+			continue
+		}
+		if top.object && top.expectKey {
+			top.key = tok.(string)
+			top.expectKey = false
+			continue
+		}
+
+		// Key of the current value
+		var key string
+		switch {
+		case top.object:
+			key = top.key
+			if top.path != "" {
+				key = top.path + "." + key
+			}
+			top.expectKey = true
+		case top.index >= 0:
+			key = fmt.Sprintf("%s[%d]", top.path, top.index)
+			top.index++
+		}
+
+		end := int(decoder.InputOffset())
+		var start int
+		switch v := tok.(type) {
+		case json.Delim:
+			stack = append(stack, &frame{
+				object:    v == '{',
+				path:      key,
+				expectKey: v == '{',
+			})
+			continue
+		case string:
+			end--
+			start = openingQuote(data, end) + 1
+		case json.Number:
+			start = end - len(v.String())
+		case bool:
+			start = end - len(strconv.FormatBool(v))
+		default:
+			continue
+		}
+
+		value := fmt.Sprint(tok)
+		if len(value) == 0 {
+			continue
+		}
+		line, col := idx.position(start)
+		endLine, endCol := idx.position(end)
+		if endLine != line {
+			endCol = idx.lineLength(line)
+		}
+		values = append(values, sgmlValue{
+			key:   key,
+			line:  line,
+			start: col,
+			end:   endCol,
+			value: value,
+		})
+	}
+}
+
+// openingQuote returns the offset of the unescaped quote
+// opening the string which closing quote is at offset end
+func openingQuote(data []byte, end int) int {
+	for i := end - 1; i >= 0; i-- {
+		if data[i] != '"' {
+			continue
+		}
+		backslashes := 0
+		for j := i - 1; j >= 0 && data[j] == '\\'; j-- {
+			backslashes++
+		}
+		if backslashes%2 == 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// closingQuote returns the index of the quote closing the string
+// starting at index start in the line or -1 if it is not found
+func closingQuote(line string, start int, quote byte) int {
+	for i := start; i < len(line); i++ {
+		switch {
+		case quote == '"' && line[i] == '\\':
+			i++
+		case quote == '\'' && line[i] == '\'' && i+1 < len(line) && line[i+1] == '\'':
+			i++
+		case line[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// runeToByteIndex converts a column expressed in characters
+// into a byte index within the line
+func runeToByteIndex(line string, col int) int {
+	idx := 0
+	for i := 0; i < col && idx < len(line); i++ {
+		_, size := utf8.DecodeRuneInString(line[idx:])
+		idx += size
+	}
+	return idx
+}
+
+// flattenXML reads the xml tokens one by one, element text is stored under
+// the path of the element (configuration.appSettings.password) while attributes
+// are stored under the path of their element followed by @ (add@connectionString).
+//
+// Elements of the form <add key="DbPassword" value="..."/> (appSettings, ...)
+// are also stored as a property named after their key (appSettings.DbPassword)
+func flattenXML(data []byte) ([]sgmlValue, error) {
+	idx := newLineIndex(data)
+	values := []sgmlValue{}
+	path := []string{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+
+	for {
+		offset := int(decoder.InputOffset())
+		tok, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return values, nil
+			}
+			return values, err
+		}
+		end := int(decoder.InputOffset())
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			key := strings.Join(path, ".")
+			attrs := scanXMLAttrs(data[offset:end])
+
+			var named string
+			for _, attr := range t.Attr {
+				if attr.Name.Local == "key" || attr.Name.Local == "name" {
+					named = attr.Value
 				}
 			}
+			for _, attr := range t.Attr {
+				if len(attr.Value) == 0 {
+					continue
+				}
+				v := xmlAttrValue(idx, attrs, offset, attr)
+				v.key = key + "@" + attr.Name.Local
+				values = append(values, v)
+				if attr.Name.Local == "value" && named != "" {
+					v.key = key[:len(key)-len(t.Name.Local)] + named
+					values = append(values, v)
+				}
+			}
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if len(text) == 0 || len(path) == 0 {
+				continue
+			}
+			first := strings.SplitN(text, "\n", 2)[0]
+			start := offset
+			if i := bytes.Index(data[offset:end], []byte(first)); i != -1 {
+				start += i
+			} else {
+				start += len(data[offset:end]) - len(bytes.TrimLeft(data[offset:end], " \t\r\n"))
+			}
+			line, col := idx.position(start)
+			stop := col + len(first)
+			if length := idx.lineLength(line); stop > length {
+				stop = length
+			}
+			values = append(values, sgmlValue{
+				key:   strings.Join(path, "."),
+				line:  line,
+				start: col,
+				end:   stop,
+				value: text,
+			})
 		}
 	}
 }
 
-func flattenMap(prefix string, value interface{}, res map[string]string) {
-	switch submap := value.(type) {
-	case map[interface{}]interface{}:
-		for k, v := range submap {
-			flattenMap(fmt.Sprintf("%s.%v", prefix, k), v, res)
+// xmlRawAttr is an attribute of a raw start element with the byte
+// bounds [start, end) of its value within the element
+type xmlRawAttr struct {
+	name       []byte
+	start, end int
+}
+
+// scanXMLAttrs lists the attributes of the raw start element, values
+// may be unquoted or missing as the decoder is not strict
+func scanXMLAttrs(raw []byte) []xmlRawAttr {
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+	}
+	isDelim := func(c byte) bool {
+		return isSpace(c) || c == '=' || c == '>' || c == '/'
+	}
+	attrs := []xmlRawAttr{}
+	i := 1
+	// Name of the element
+	for i < len(raw) && !isDelim(raw[i]) {
+		i++
+	}
+	for i < len(raw) {
+		for i < len(raw) && (isSpace(raw[i]) || raw[i] == '/') {
+			i++
 		}
-		return
-	case []interface{}:
-		for i, v := range submap {
-			flattenMap(fmt.Sprintf("%s[%d]", prefix, i), v, res)
+		if i >= len(raw) || raw[i] == '>' {
+			break
 		}
-		return
-	case map[string]interface{}:
-		for k, v := range submap {
-			flattenMap(prefix+"."+k, v, res)
+		nameStart := i
+		for i < len(raw) && !isDelim(raw[i]) {
+			i++
 		}
-		return
-	default:
-		res[prefix] = fmt.Sprintf("%v", value)
+		attr := xmlRawAttr{name: raw[nameStart:i], start: nameStart, end: i}
+		j := i
+		for j < len(raw) && isSpace(raw[j]) {
+			j++
+		}
+		if j < len(raw) && raw[j] == '=' {
+			j++
+			for j < len(raw) && isSpace(raw[j]) {
+				j++
+			}
+			if j < len(raw) && (raw[j] == '"' || raw[j] == '\'') {
+				closing := bytes.IndexByte(raw[j+1:], raw[j])
+				if closing == -1 {
+					closing = len(raw) - j - 1
+				}
+				attr.start, attr.end = j+1, j+1+closing
+				i = attr.end + 1
+			} else {
+				attr.start = j
+				for j < len(raw) && !isDelim(raw[j]) {
+					j++
+				}
+				attr.end, i = j, j
+			}
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs
+}
+
+// xmlAttrValue locates the value of the attribute within the attributes of
+// the raw start element which begins at the given offset of the file
+func xmlAttrValue(idx *lineIndex, attrs []xmlRawAttr, offset int, attr xml.Attr) sgmlValue {
+	v := sgmlValue{value: attr.Value}
+	var found *xmlRawAttr
+	for i := range attrs {
+		name := attrs[i].name
+		if colon := bytes.LastIndexByte(name, ':'); colon != -1 {
+			name = name[colon+1:]
+		}
+		if string(name) == attr.Name.Local {
+			found = &attrs[i]
+			break
+		}
+	}
+	if found == nil {
+		v.line, _ = idx.position(offset)
+		v.start, v.end = 0, idx.lineLength(v.line)
+		return v
 	}
+
+	line, col := idx.position(offset + found.start)
+	endLine, endCol := idx.position(offset + found.end)
+	if endLine != line {
+		endCol = idx.lineLength(line)
+	}
+	v.line, v.start, v.end = line, col, endCol
+	return v
 }
+
 func newJSONParser(keyBag *[]string) *sgmlParser {
-	return newSGMLParser(keyBag, json.Unmarshal)
+	return newSGMLParser(keyBag, flattenJSON)
 }
 
 func newYAMLParser(keyBag *[]string) *sgmlParser {
-	return newSGMLParser(keyBag, yaml.Unmarshal)
+	return newSGMLParser(keyBag, flattenYAML)
 }
 
 func newXMLParser(keyBag *[]string) *sgmlParser {
-	return newSGMLParser(keyBag, xml.Unmarshal)
+	return newSGMLParser(keyBag, flattenXML)
 }
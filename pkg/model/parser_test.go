@@ -1,6 +1,7 @@
 package model
 
 import (
+	"os"
 	"strings"
 	"testing"
 )
@@ -100,3 +101,264 @@ func TestJson(t *testing.T) {
 	ch := make(chan Leak)
 	go p.Parse(reader, ch, "", nil)
 }
+
+func TestHCL(t *testing.T) {
+	p := newHCLParser(&[]string{
+		"password",
+		"secret",
+		"hostname",
+	})
+	reader := strings.NewReader(`
+# Database of the application
+resource "aws_db_instance" "default" {
+  engine   = "mysql"
+  username = "foo"
+  password = "foobarbaz" // hardcoded
+  /* a block comment
+     password = "not a password" */
+  parameter_group_name = "default.mysql5.7"
+  password_min_length  = 8
+  manage_password      = true
+}
+
+provider "aws" {
+  secret_key = var.aws_secret_key
+  assume_role {
+    role_arn = "arn:aws:iam::123456789012:role/ROLE_NAME"
+  }
+}
+
+locals {
+  settings = {
+    db_password = "${data.vault_generic_secret.db.data["password"]}"
+    secrets     = ["hunter2", var.other]
+  }
+  policy = <<EOF
+{ "password": "heredoc" }
+EOF
+}
+`)
+	ch := make(chan Leak)
+	go func() {
+		p.Parse(reader, ch, "main.tf", nil)
+		close(ch)
+	}()
+
+	expected := []struct {
+		line       int
+		key        string
+		value      string
+		confidence string
+	}{
+		{6, "resource.aws_db_instance.default.password", "foobarbaz", "High"},
+		{15, "provider.aws.secret_key", "var.aws_secret_key", "Medium"},
+		{23, "locals.settings.db_password", `${data.vault_generic_secret.db.data["password"]}`, "Medium"},
+		{24, "locals.settings.secrets", "hunter2", "High"},
+		{24, "locals.settings.secrets", "var.other", "Medium"},
+	}
+	leaks := []FileLeak{}
+	for leak := range ch {
+		leaks = append(leaks, leak.(FileLeak))
+	}
+	if len(leaks) != len(expected) {
+		t.Fatalf("expected %d leaks, got %d: %+v", len(expected), len(leaks), leaks)
+	}
+	for idx, leak := range leaks {
+		value := leak.Match()
+		if leak.Line != expected[idx].line || leak.Key != expected[idx].key || value != expected[idx].value || leak.Confidence != expected[idx].confidence {
+			t.Errorf("expected %+v, got line %d, key %s, value %q, confidence %s", expected[idx], leak.Line, leak.Key, value, leak.Confidence)
+		}
+	}
+}
+
+func TestHCLFixture(t *testing.T) {
+	p := newHCLParser(&[]string{
+		"domain",
+		"hostname",
+	})
+	fd, err := os.Open("tests/terraform-aws-rds.tf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	ch := make(chan Leak)
+	go func() {
+		p.Parse(fd, ch, fd.Name(), nil)
+		close(ch)
+	}()
+	lines := []int{}
+	for leak := range ch {
+		if leak.(FileLeak).Confidence != "Medium" {
+			t.Errorf("expected interpolated values to be downgraded, got %+v", leak)
+		}
+		lines = append(lines, leak.(FileLeak).Line)
+	}
+	if len(lines) != 2 || lines[0] != 8 || lines[1] != 25 {
+		t.Errorf("expected leaks on lines [8 25], got %v", lines)
+	}
+}
+
+func collectFileLeaks(parse func(chan Leak)) []FileLeak {
+	ch := make(chan Leak)
+	go func() {
+		parse(ch)
+		close(ch)
+	}()
+	leaks := []FileLeak{}
+	for leak := range ch {
+		leaks = append(leaks, leak.(FileLeak))
+	}
+	return leaks
+}
+
+func TestYamlPosition(t *testing.T) {
+	p := newYAMLParser(&[]string{"password", "token"})
+	content := `apiVersion: v1
+kind: Secret
+stringData:
+  password: hunter2
+  "token": "ab\"cd"
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  users:
+    - name: admin
+      password: 'it''s'
+  token: |
+    multiline
+`
+	leaks := collectFileLeaks(func(ch chan Leak) {
+		p.Parse(strings.NewReader(content), ch, "secret.yaml", nil)
+	})
+
+	expected := []struct {
+		line  int
+		value string
+	}{
+		{4, "hunter2"},
+		{5, `ab\"cd`},
+		{12, "it''s"},
+		{13, "|"},
+	}
+	if len(leaks) != len(expected) {
+		t.Fatalf("expected %d leaks, got %d: %+v", len(expected), len(leaks), leaks)
+	}
+	for idx, leak := range leaks {
+		affected := leak.Snippet[leak.Affected]
+		if leak.Line != expected[idx].line || affected[leak.StartIdx:leak.EndIdx] != expected[idx].value {
+			t.Errorf("expected %+v, got line %d, value %q", expected[idx], leak.Line, affected[leak.StartIdx:leak.EndIdx])
+		}
+	}
+}
+
+func TestJsonPosition(t *testing.T) {
+	p := newJSONParser(&[]string{"password", "token"})
+	fd, err := os.Open("tests/.docker.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	leaks := collectFileLeaks(func(ch chan Leak) {
+		p.Parse(fd, ch, fd.Name(), nil)
+	})
+	if len(leaks) != 1 || leaks[0].Line != 5 {
+		t.Fatalf("expected a single leak on line 5, got %+v", leaks)
+	}
+
+	content := `{
+  "auths": [
+    {"registry": "quay.io", "token": "a\"b\\"},
+    {"registry": "gcr.io", "password": 12345}
+  ],
+  "nested": {"deep": {"password": "secret"}}
+}`
+	leaks = collectFileLeaks(func(ch chan Leak) {
+		p.Parse(strings.NewReader(content), ch, "config.json", nil)
+	})
+	expected := []struct {
+		line  int
+		value string
+	}{
+		{3, `a\"b\\`},
+		{4, "12345"},
+		{6, "secret"},
+	}
+	if len(leaks) != len(expected) {
+		t.Fatalf("expected %d leaks, got %d: %+v", len(expected), len(leaks), leaks)
+	}
+	for idx, leak := range leaks {
+		affected := leak.Snippet[leak.Affected]
+		if leak.Line != expected[idx].line || affected[leak.StartIdx:leak.EndIdx] != expected[idx].value {
+			t.Errorf("expected %+v, got line %d, value %q", expected[idx], leak.Line, affected[leak.StartIdx:leak.EndIdx])
+		}
+	}
+}
+
+func TestXml(t *testing.T) {
+	p := newXMLParser(&[]string{"password", "connectionstring"})
+	content := `<?xml version="1.0" encoding="utf-8"?>
+<configuration>
+  <connectionStrings>
+    <add name="Default"
+         connectionString="Server=db;User Id=sa;Password=hunter2;" />
+  </connectionStrings>
+  <appSettings>
+    <add key="SmtpPassword" value="s3cr3t" />
+    <add key="Theme" value="dark" />
+  </appSettings>
+  <servers>
+    <server>
+      <id>nexus</id>
+      <password>
+        maven-secret
+      </password>
+      <passwordPolicy enabled="true"/>
+    </server>
+  </servers>
+</configuration>`
+	leaks := collectFileLeaks(func(ch chan Leak) {
+		p.Parse(strings.NewReader(content), ch, "web.config", nil)
+	})
+
+	expected := []struct {
+		line  int
+		value string
+	}{
+		{5, "Server=db;User Id=sa;Password=hunter2;"},
+		{8, "s3cr3t"},
+		{15, "maven-secret"},
+	}
+	if len(leaks) != len(expected) {
+		t.Fatalf("expected %d leaks, got %d: %+v", len(expected), len(leaks), leaks)
+	}
+	for idx, leak := range leaks {
+		affected := leak.Snippet[leak.Affected]
+		if leak.Line != expected[idx].line || affected[leak.StartIdx:leak.EndIdx] != expected[idx].value {
+			t.Errorf("expected %+v, got line %d, value %q", expected[idx], leak.Line, affected[leak.StartIdx:leak.EndIdx])
+		}
+	}
+}
+
+func TestScanXMLAttrs(t *testing.T) {
+	raw := []byte(`<add key = 'a>b' xml:lang="en" disabled value=plain/>`)
+	expected := []struct {
+		name  string
+		value string
+	}{
+		{"key", "a>b"},
+		{"xml:lang", "en"},
+		{"disabled", "disabled"},
+		{"value", "plain"},
+	}
+	attrs := scanXMLAttrs(raw)
+	if len(attrs) != len(expected) {
+		t.Fatalf("expected %d attributes, got %d", len(expected), len(attrs))
+	}
+	for idx, attr := range attrs {
+		if string(attr.name) != expected[idx].name || string(raw[attr.start:attr.end]) != expected[idx].value {
+			t.Errorf("expected %+v, got %s=%q", expected[idx], attr.name, raw[attr.start:attr.end])
+		}
+	}
+}
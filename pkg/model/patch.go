@@ -0,0 +1,230 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// PatchFile is a file of a unified diff
+type PatchFile struct {
+	// Path of the file after the patch (empty if the file is deleted)
+	Path string
+	// Path of the file before the patch (empty if the file is created)
+	OldPath string
+	// Binary files do not have hunks
+	Binary bool
+	Hunks  []Hunk
+}
+
+// Hunk is a part of the patch of a file
+type Hunk struct {
+	// Line number of the first line of the hunk in the new version of the file
+	NewStart int
+	// Lines of the new version of the file covered by the hunk (unchanged
+	// and added lines), the second slice flags which of them were added
+	Lines []string
+	Added []bool
+}
+
+// ParseUnifiedDiff reads a unified diff such as the output of diff -u,
+// git diff or git format-patch. Text which is not part of a file patch
+// (commit messages, mail headers) is ignored.
+func ParseUnifiedDiff(r io.Reader) ([]PatchFile, error) {
+	reader := bufio.NewReader(r)
+	files := []PatchFile{}
+	var file *PatchFile
+	var hunk *Hunk
+	// Lines of the hunk which have not been read yet on each side
+	oldRemaining, newRemaining := 0, 0
+	// Whether or not the paths are prefixed by a/ and b/
+	gitFormat := false
+	// Whether or not the --- line of the current file has been read
+	oldHeader := false
+
+	lineNumber := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+		lineNumber++
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+
+		if hunk != nil && (oldRemaining > 0 || newRemaining > 0) {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				hunk.Lines = append(hunk.Lines, line[1:])
+				hunk.Added = append(hunk.Added, true)
+				newRemaining--
+			case strings.HasPrefix(line, "-"):
+				oldRemaining--
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file"
+			case strings.HasPrefix(line, " "), line == "":
+				// Some editors strip the space of empty unchanged lines
+				hunk.Lines = append(hunk.Lines, strings.TrimPrefix(line, " "))
+				hunk.Added = append(hunk.Added, false)
+				oldRemaining--
+				newRemaining--
+			default:
+				return nil, fmt.Errorf("line %d: unexpected line in hunk %q", lineNumber, line)
+			}
+			if err == io.EOF {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(line, `\`) {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			files = append(files, PatchFile{})
+			file = &files[len(files)-1]
+			hunk = nil
+			gitFormat = true
+			oldHeader = false
+			if old, new, ok := splitGitPaths(line[len("diff --git "):]); ok {
+				file.OldPath, file.Path = old, new
+			}
+		case strings.HasPrefix(line, "--- "):
+			// Plain unified diffs do not have a diff --git header
+			if file == nil || oldHeader || file.Binary {
+				files = append(files, PatchFile{})
+				file = &files[len(files)-1]
+				hunk = nil
+				gitFormat = false
+			}
+			file.OldPath = patchPath(line[len("--- "):])
+			oldHeader = true
+		case strings.HasPrefix(line, "+++ ") && file != nil:
+			file.Path = patchPath(line[len("+++ "):])
+			if !gitFormat && strings.HasPrefix(file.OldPath, "a/") && strings.HasPrefix(file.Path, "b/") {
+				gitFormat = true
+			}
+			if gitFormat {
+				file.OldPath = strings.TrimPrefix(file.OldPath, "a/")
+				file.Path = strings.TrimPrefix(file.Path, "b/")
+			}
+		case strings.HasPrefix(line, "new file mode") && file != nil:
+			file.OldPath = ""
+		case strings.HasPrefix(line, "deleted file mode") && file != nil:
+			file.Path = ""
+		case strings.HasPrefix(line, "rename from ") && file != nil:
+			file.OldPath = unquotePath(line[len("rename from "):])
+		case strings.HasPrefix(line, "rename to ") && file != nil:
+			file.Path = unquotePath(line[len("rename to "):])
+		case (strings.HasPrefix(line, "Binary files ") || line == "GIT binary patch") && file != nil:
+			file.Binary = true
+		case strings.HasPrefix(line, "@@ ") && file != nil:
+			oldCount, newStart, newCount, headerErr := parseHunkHeader(line)
+			if headerErr != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, headerErr)
+			}
+			file.Hunks = append(file.Hunks, Hunk{NewStart: newStart})
+			hunk = &file.Hunks[len(file.Hunks)-1]
+			oldRemaining, newRemaining = oldCount, newCount
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return files, nil
+}
+
+// parseHunkHeader parses the "@@ -l,s +l,s @@" header of a hunk
+// and returns the amount of old lines, the first new line and
+// the amount of new lines (counts are 1 when omitted)
+func parseHunkHeader(line string) (int, int, int, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, 0, fmt.Errorf("invalid hunk header %q", line)
+	}
+	_, oldCount, err := parseRange(fields[1][1:])
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid hunk header %q: %w", line, err)
+	}
+	newStart, newCount, err := parseRange(fields[2][1:])
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid hunk header %q: %w", line, err)
+	}
+	return oldCount, newStart, newCount, nil
+}
+
+// parseRange parses the "start,count" range of a hunk header
+func parseRange(value string) (int, int, error) {
+	count := 1
+	if idx := strings.IndexByte(value, ','); idx != -1 {
+		var err error
+		if count, err = strconv.Atoi(value[idx+1:]); err != nil {
+			return 0, 0, err
+		}
+		value = value[:idx]
+	}
+	start, err := strconv.Atoi(value)
+	return start, count, err
+}
+
+// patchPath returns the path of a ---/+++ line without its
+// timestamp, /dev/null being the absence of file
+func patchPath(value string) string {
+	if idx := strings.IndexByte(value, '\t'); idx != -1 {
+		value = value[:idx]
+	}
+	value = unquotePath(value)
+	if value == "/dev/null" {
+		return ""
+	}
+	return value
+}
+
+// unquotePath unquotes the paths git quotes (special characters)
+func unquotePath(value string) string {
+	if strings.HasPrefix(value, `"`) {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+	}
+	return value
+}
+
+// splitGitPaths splits the "a/old b/new" paths of a diff --git header,
+// used for the files which have no ---/+++ lines (binary, mode changes)
+func splitGitPaths(value string) (string, string, bool) {
+	if strings.HasPrefix(value, `"`) {
+		return "", "", false
+	}
+	// Both paths are the same unless the file is renamed,
+	// in which case the rename lines give the paths
+	n := len(value)
+	if n%2 == 1 && value[n/2] == ' ' && strings.HasPrefix(value, "a/") && value[2:n/2] == value[n/2+3:] {
+		return value[2 : n/2], value[n/2+3:], true
+	}
+	return "", "", false
+}
+
+// ParsePatchFile applies the rules to the lines added by each hunk of
+// the file and sends the leaks as FileLeaks with the line numbers of
+// the new version of the file. Context parsers only see the hunks.
+func (r *RuleSet) ParsePatchFile(file PatchFile, leakChan chan Leak) {
+	if file.Binary || file.Path == "" || r.isBlackListed(file.Path) {
+		return
+	}
+	for _, hunk := range file.Hunks {
+		offset := hunk.NewStart - 1
+		r.parseLines(file.Path, hunk.Lines, hunk.Added, func(found FileLeak) {
+			found.Line += offset
+			if found.EndLine != 0 {
+				found.EndLine += offset
+			}
+			leakChan <- found
+		})
+	}
+}
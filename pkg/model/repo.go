@@ -1,13 +1,20 @@
 package model
 
 import (
+	"context"
+	"fmt"
 	"net/url"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/rs/zerolog/log"
 )
 
@@ -16,80 +23,375 @@ import (
 type Repo struct {
 	Source string `yaml:"source"`
 	Path   string
-	Since  time.Time
-	Storer *git.Repository
+
+	// Only fetch commits more recent than Since (ignored if zero)
+	Since time.Time `yaml:"since,omitempty"`
+	// Only fetch commits older than Until (ignored if zero)
+	Until time.Time `yaml:"until,omitempty"`
+	// Revision from which commits are fetched (defaults to HEAD)
+	To string `yaml:"to,omitempty"`
+	// Revision which ancestors are excluded from the fetched commits
+	// (similar to git log From..To), can also be given as a range "main..feature"
+	From string `yaml:"from,omitempty"`
+	// Maximum amount of commits to fetch (0 for no limit)
+	MaxCommits int `yaml:"max_commits,omitempty"`
+
+	// Fetch commits from every branch, remote-tracking ref and tag instead of To
+	AllRefs bool `yaml:"all_refs,omitempty"`
+	// Glob patterns of the refs to include / exclude in AllRefs mode, matched
+	// against the full and short names of the ref ("refs/tags/*", "release/*")
+	RefInclude []string `yaml:"ref_include,omitempty"`
+	RefExclude []string `yaml:"ref_exclude,omitempty"`
+
+	Storer *git.Repository `yaml:"-"`
+
+	// tips of the last walk in AllRefs mode and names
+	// of the refs containing the commits resolved so far
+	tips   []*plumbing.Reference
+	refsMu sync.Mutex
+	refs   map[plumbing.Hash][]string
+
+	// commits reachable from the excluded revision, kept so that
+	// successive walks of the commits only load them once
+	excludedKey string
+	excluded    map[plumbing.Hash]bool
 }
 
-// Init creates a repository struct
-func (r *Repo) Init(source, cache string) {
+// Init creates a repository struct, remote repositories are cloned
+// in the cache directory unless they already have been
+func (r *Repo) Init(ctx context.Context, source, cache string) error {
 	r.Source = source
 	if _, err := url.Parse(source); err != nil {
 		log.Warn().
 			Err(err).
 			Msg("Git source given is not a remote URL, interpreting as local")
 		r.Path, _ = filepath.Abs(filepath.Base(source))
-		r.Storer = r.PlainOpen(source)
+		r.Storer, err = r.PlainOpen(source)
+		return err
+	}
+
+	r.Path = path.Join(cache, path.Base(r.Source))
+	log.Info().
+		Str("repository", r.Path).
+		Msg("Cloning")
+
+	ref, err := git.PlainCloneContext(ctx, r.Path, false, &git.CloneOptions{
+		URL: r.Source,
+	})
+	if err == git.ErrRepositoryAlreadyExists {
+		r.Storer, err = r.PlainOpen(r.Path)
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to clone repository %s: %w", r.Source, err)
+	}
+	r.Storer = ref
+	return nil
+}
+
+// PlainOpen attempts to use go-git's to open a cloned repository
+func (r *Repo) PlainOpen(repoPath string) (*git.Repository, error) {
+	ref, err := git.PlainOpen(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository %s: %w", repoPath, err)
+	}
+	return ref, nil
+}
+
+// FetchCommits stores all commits walked by WalkCommits in a slice
+//
+// The whole history is kept in memory, the scanners
+// stream the commits with WalkCommits instead.
+func (r *Repo) FetchCommits() ([]*object.Commit, error) {
+	commits := []*object.Commit{}
+	err := r.WalkCommits(func(commit *object.Commit) error {
+		commits = append(commits, commit)
+		return nil
+	})
+	return commits, err
+}
+
+// WalkCommits calls fn on each commit as it is read from the history,
+// the walk stops on the first error returned by fn.
+//
+// Only commits reachable from To (or any of the refs in AllRefs mode),
+// not reachable from From and committed between Since and Until are walked.
+// Commits shared by multiple refs are only walked once.
+// The refs containing a commit are resolved when asked for (see Refs).
+//
+func (r *Repo) WalkCommits(fn func(*object.Commit) error) error {
+	tips, excluded, err := r.commitRange()
+	if err != nil {
+		return err
+	}
+	if r.AllRefs {
+		r.refsMu.Lock()
+		r.tips, r.refs = tips, map[plumbing.Hash][]string{}
+		r.refsMu.Unlock()
+	}
+	return r.walk(tips, excluded, fn)
+}
+
+// commitRange returns the tips from which commits are walked
+// and the set of commits reachable from the excluded revision
+func (r *Repo) commitRange() ([]*plumbing.Reference, map[plumbing.Hash]bool, error) {
+	from, to := r.From, r.To
+	if idx := strings.Index(from, ".."); idx != -1 {
+		from, to = from[:idx], from[idx+2:]
+	}
+	if to == "" {
+		to = "HEAD"
+	}
+
+	var tips []*plumbing.Reference
+	if r.AllRefs {
+		var err error
+		if tips, err = r.listRefs(); err != nil {
+			return nil, nil, err
+		}
 	} else {
-		r.Path = path.Join(cache, path.Base(r.Source))
-		log.Info().
-			Str("repository", r.Path).
-			Msg("Cloning")
+		hash, err := r.resolve(to)
+		if err != nil {
+			return nil, nil, err
+		}
+		tips = []*plumbing.Reference{
+			plumbing.NewHashReference(plumbing.ReferenceName(to), hash),
+		}
+	}
 
-		ref, err := git.PlainClone(r.Path, false, &git.CloneOptions{
-			URL: r.Source,
-		})
+	if from == "" {
+		return tips, nil, nil
+	}
+	hash, err := r.resolve(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	excluded, err := r.excludedCommits(hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to fetch commits excluded by %s: %w", from, err)
+	}
+	return tips, excluded, nil
+}
+
+// excludedCommits returns the commits reachable from the excluded revision
+// which are more recent than Since (the walk stops at older commits anyway),
+// the set is reused as long as the revision and Since do not change
+func (r *Repo) excludedCommits(from plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	key := from.String() + "@" + r.Since.String()
+	if r.excluded != nil && r.excludedKey == key {
+		return r.excluded, nil
+	}
+	commit, err := r.Storer.CommitObject(from)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := map[plumbing.Hash]bool{}
+	iter := object.NewCommitIterCTime(commit, nil, nil)
+	err = iter.ForEach(func(o *object.Commit) error {
+		if r.beforeSince(o) {
+			return storer.ErrStop
+		}
+		excluded[o.Hash] = true
+		return nil
+	})
+	iter.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.excluded, r.excludedKey = excluded, key
+	return excluded, nil
+}
+
+// beforeSince checks whether or not the commit is older than Since
+func (r *Repo) beforeSince(commit *object.Commit) bool {
+	return !r.Since.IsZero() && commit.Committer.When.Before(r.Since)
+}
+
+// walk iterates over the commits reachable from the tips, the commits
+// of each tip are walked from the most recent ones so that the walk
+// stops at the first commit older than Since
+func (r *Repo) walk(tips []*plumbing.Reference, excluded map[plumbing.Hash]bool, fn func(*object.Commit) error) error {
+	// Commits reachable from the excluded revision are marked as seen
+	// which stops the walk as soon as the histories meet
+	seen := make(map[plumbing.Hash]bool, len(excluded))
+	for hash := range excluded {
+		seen[hash] = true
+	}
+
+	walked := 0
+	for _, tip := range tips {
+		if r.MaxCommits > 0 && walked >= r.MaxCommits {
+			break
+		}
+		tipCommit, err := r.Storer.CommitObject(tip.Hash())
 		if err != nil {
-			if err == git.ErrRepositoryAlreadyExists {
-				r.Storer = r.PlainOpen(r.Path)
-				return
+			return fmt.Errorf("unable to fetch commit of %s: %w", tip.Name(), err)
+		}
+
+		// Commits walked from the previous refs are also marked as seen
+		// so that shared histories are only walked once
+		commitIter := object.NewCommitIterCTime(tipCommit, seen, nil)
+		var fnErr error
+		err = commitIter.ForEach(func(o *object.Commit) error {
+			if r.MaxCommits > 0 && walked >= r.MaxCommits || r.beforeSince(o) {
+				return storer.ErrStop
 			}
-			log.Fatal().
+			seen[o.Hash] = true
+			if !r.Until.IsZero() && o.Committer.When.After(r.Until) {
+				return nil
+			}
+			walked++
+			if fnErr = fn(o); fnErr != nil {
+				return storer.ErrStop
+			}
+			return nil
+		})
+		commitIter.Close()
+		if fnErr != nil {
+			return fnErr
+		}
+		// Missing objects (shallow clones) only end the walk of the ref
+		if err != nil {
+			log.Warn().
+				Str("ref", tip.Name().String()).
 				Err(err).
-				Msg("Failed to open repository")
+				Msg("Unable to walk all commits")
 		}
-		r.Storer = ref
 	}
+	return nil
 }
 
-// PlainOpen attempts to use go-git's to open a cloned repository
-func (r *Repo) PlainOpen(repoPath string) *git.Repository {
-	ref, err := git.PlainOpen(r.Path)
+// Refs returns the names of the refs containing the commit (only available
+// after fetching commits in AllRefs mode), they are resolved when first
+// asked for so that only the commits with findings are looked up
+func (r *Repo) Refs(hash plumbing.Hash) []string {
+	if r == nil {
+		return nil
+	}
+	r.refsMu.Lock()
+	tips := r.tips
+	refs, ok := r.refs[hash]
+	r.refsMu.Unlock()
+	if ok || len(tips) == 0 {
+		return refs
+	}
+
+	refs = r.containing(tips, hash)
+	r.refsMu.Lock()
+	r.refs[hash] = refs
+	r.refsMu.Unlock()
+	return refs
+}
+
+// containing returns the names of the tips from which the commit is
+// reachable. The walk from each tip does not go past the commits older
+// than the commit, as git does refs may be missed on clock skews.
+func (r *Repo) containing(tips []*plumbing.Reference, hash plumbing.Hash) []string {
+	target, err := r.Storer.CommitObject(hash)
 	if err != nil {
-		log.Fatal().
-			Str("path", r.Path).
-			Err(err).
-			Msg("Repo failed to open @")
+		return nil
+	}
+	refs := []string{}
+	for _, tip := range tips {
+		seen := map[plumbing.Hash]bool{}
+		stack := []plumbing.Hash{tip.Hash()}
+		for len(stack) > 0 {
+			current := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if current == hash {
+				refs = append(refs, tip.Name().Short())
+				break
+			}
+			if seen[current] {
+				continue
+			}
+			seen[current] = true
+			commit, err := r.Storer.CommitObject(current)
+			if err != nil || commit.Committer.When.Before(target.Committer.When) {
+				continue
+			}
+			stack = append(stack, commit.ParentHashes...)
+		}
 	}
-	return ref
+	sort.Strings(refs)
+	return refs
 }
 
-// FetchCommits stores all commits in a slice
-//
-// This allows for concurrent r/w of the commit slice
-// without having to go through go-git's commit iterator
-// object. (Comes at a certain cost to memory but the
-// commit object itself seems to be very light)
-//
-func (r *Repo) FetchCommits() []*object.Commit {
-	// Fetch repository head
-	ref, err := r.Storer.Head()
+// listRefs returns every branch, remote-tracking ref and tag
+// matching the include / exclude patterns peeled to their commit
+func (r *Repo) listRefs() ([]*plumbing.Reference, error) {
+	iter, err := r.Storer.References()
 	if err != nil {
-		log.Fatal().
-			Err(err).
-			Msg("Unable to fetch head")
+		return nil, fmt.Errorf("unable to list references: %w", err)
 	}
-	commitIter, _ := r.Storer.Log(&git.LogOptions{
-		From:  ref.Hash(),
-		Order: git.LogOrderCommitterTime,
-	})
 
-	// Append all commits to a slice
-	// (Allows for better concurrent access than the base commitIter)
-	commits := []*object.Commit{}
-	commitIter.ForEach(func(o *object.Commit) error {
-		commits = append(commits, o)
+	defer iter.Close()
+
+	tips := []*plumbing.Reference{}
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		// Symbolic refs (HEAD, origin/HEAD) point to other listed refs
+		if ref.Type() != plumbing.HashReference || !r.matchRef(ref.Name()) {
+			return nil
+		}
+		hash := ref.Hash()
+		// Annotated tags are peeled to the commit they point to
+		if tag, err := r.Storer.TagObject(hash); err == nil {
+			commit, err := tag.Commit()
+			if err != nil {
+				log.Debug().
+					Str("ref", ref.Name().String()).
+					Msg("Skipping tag not pointing to a commit")
+				return nil
+			}
+			hash = commit.Hash
+		} else if _, err := r.Storer.CommitObject(hash); err != nil {
+			log.Debug().
+				Str("ref", ref.Name().String()).
+				Msg("Skipping ref not pointing to a commit")
+			return nil
+		}
+		tips = append(tips, plumbing.NewHashReference(ref.Name(), hash))
 		return nil
 	})
-	commitIter.Close()
-	return commits
+	if err != nil {
+		return nil, fmt.Errorf("unable to list references: %w", err)
+	}
+	sort.Slice(tips, func(i, j int) bool {
+		return tips[i].Name() < tips[j].Name()
+	})
+
+	log.Info().
+		Int("refs", len(tips)).
+		Msg("Scanning all refs")
+	return tips, nil
+}
+
+// matchRef checks the ref name against the include and exclude patterns
+func (r *Repo) matchRef(name plumbing.ReferenceName) bool {
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name.String()); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, name.Short()); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if len(r.RefInclude) > 0 && !match(r.RefInclude) {
+		return false
+	}
+	return !match(r.RefExclude)
+}
+
+// resolve returns the hash of the commit pointed by the revision
+// (branch, tag, hash, HEAD~2, ...)
+func (r *Repo) resolve(rev string) (plumbing.Hash, error) {
+	hash, err := r.Storer.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("unable to resolve revision %s: %w", rev, err)
+	}
+	return *hash, nil
 }
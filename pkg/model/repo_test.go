@@ -0,0 +1,260 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/ichbinfrog/excavator/internal/gittest"
+)
+
+// newTestRepo creates an in memory repository with the history
+//
+//	c0 - c1 - c2 - c3 (master)
+//	     |\
+//	     | f1 - f2 (feature)
+//	     v1 (annotated tag)
+//
+// where commit ci is committed on day i of 2020
+func newTestRepo(t *testing.T) (*Repo, map[string]plumbing.Hash) {
+	repo := gittest.NewRepo(t)
+	hashes := map[string]plumbing.Hash{}
+	commit := func(name string, day int) {
+		repo.Stage(name+".txt", name)
+		hashes[name] = repo.Commit(name, time.Date(2020, 1, day+1, 0, 0, 0, 0, time.UTC))
+	}
+
+	commit("c0", 0)
+	commit("c1", 1)
+	if _, err := repo.CreateTag("v1", hashes["c1"], &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "excavator", When: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		Message: "v1",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	repo.Checkout("feature", true)
+	commit("f1", 4)
+	commit("f2", 5)
+	repo.Checkout("master", false)
+	commit("c2", 2)
+	commit("c3", 3)
+	return &Repo{Storer: repo.Repository}, hashes
+}
+
+func TestFetchCommits(t *testing.T) {
+	repo, hashes := newTestRepo(t)
+
+	tests := []struct {
+		name     string
+		setup    func(r *Repo)
+		expected []string
+	}{
+		{"head", func(r *Repo) {}, []string{"c3", "c2", "c1", "c0"}},
+		{"max commits", func(r *Repo) { r.MaxCommits = 2 }, []string{"c3", "c2"}},
+		{"since", func(r *Repo) { r.Since = time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC) }, []string{"c3", "c2"}},
+		{"until", func(r *Repo) { r.Until = time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC) }, []string{"c1", "c0"}},
+		{"to", func(r *Repo) { r.To = "feature" }, []string{"f2", "f1", "c1", "c0"}},
+		{"from to", func(r *Repo) { r.From, r.To = "master", "feature" }, []string{"f2", "f1"}},
+		{"range", func(r *Repo) { r.From = "feature..master" }, []string{"c3", "c2"}},
+		{"hash range", func(r *Repo) { r.From = hashes["c1"].String() + "..HEAD~1" }, []string{"c2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &Repo{Storer: repo.Storer}
+			test.setup(r)
+			commits, err := r.FetchCommits()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(commits) != len(test.expected) {
+				t.Fatalf("expected %v, got %d commits", test.expected, len(commits))
+			}
+			for idx, commit := range commits {
+				if commit.Hash != hashes[test.expected[idx]] {
+					t.Errorf("expected %s at index %d, got %s", test.expected[idx], idx, commit.Message)
+				}
+			}
+		})
+	}
+}
+
+// readCountingStorage counts the objects read from the storage
+type readCountingStorage struct {
+	*memory.Storage
+	reads map[plumbing.Hash]int
+}
+
+func (s *readCountingStorage) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	s.reads[h]++
+	return s.Storage.EncodedObject(t, h)
+}
+
+func TestWalkCommitsSince(t *testing.T) {
+	repo, hashes := newTestRepo(t)
+	storage := &readCountingStorage{
+		Storage: repo.Storer.Storer.(*memory.Storage),
+		reads:   map[plumbing.Hash]int{},
+	}
+	storer, err := git.Open(storage, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Walks stop at the first commit older than Since, only
+	// the parents of the commits which were walked are read
+	r := &Repo{Storer: storer, Since: time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC)}
+	commits, err := r.FetchCommits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[0].Hash != hashes["c3"] {
+		t.Errorf("expected c3 to be the only commit, fetched %d", len(commits))
+	}
+	if reads := storage.reads[hashes["c0"]]; reads != 0 {
+		t.Errorf("expected c0 not to be read, got %d reads", reads)
+	}
+
+	// The excluded commits are loaded once for successive walks
+	r.From = "feature"
+	if _, err := r.FetchCommits(); err != nil {
+		t.Fatal(err)
+	}
+	excluded := r.excluded
+	if _, err := r.FetchCommits(); err != nil {
+		t.Fatal(err)
+	}
+	if len(excluded) != 2 || reflect.ValueOf(r.excluded).Pointer() != reflect.ValueOf(excluded).Pointer() {
+		t.Errorf("expected the excluded commits to be reused, got %v and %v", excluded, r.excluded)
+	}
+}
+
+func TestFetchAllRefs(t *testing.T) {
+	repo, hashes := newTestRepo(t)
+
+	tests := []struct {
+		name     string
+		setup    func(r *Repo)
+		expected map[string][]string
+	}{
+		{"all", func(r *Repo) {}, map[string][]string{
+			"c0": {"feature", "master", "v1"},
+			"c1": {"feature", "master", "v1"},
+			"c2": {"master"},
+			"c3": {"master"},
+			"f1": {"feature"},
+			"f2": {"feature"},
+		}},
+		{"exclude", func(r *Repo) { r.RefExclude = []string{"refs/tags/*"} }, map[string][]string{
+			"c0": {"feature", "master"},
+			"c1": {"feature", "master"},
+			"c2": {"master"},
+			"c3": {"master"},
+			"f1": {"feature"},
+			"f2": {"feature"},
+		}},
+		{"include", func(r *Repo) { r.RefInclude = []string{"feat*"} }, map[string][]string{
+			"c0": {"feature"},
+			"c1": {"feature"},
+			"f1": {"feature"},
+			"f2": {"feature"},
+		}},
+		{"from", func(r *Repo) { r.From = "v1" }, map[string][]string{
+			"c2": {"master"},
+			"c3": {"master"},
+			"f1": {"feature"},
+			"f2": {"feature"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &Repo{Storer: repo.Storer, AllRefs: true}
+			test.setup(r)
+			commits, err := r.FetchCommits()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(commits) != len(test.expected) {
+				t.Fatalf("expected %d commits, got %d", len(test.expected), len(commits))
+			}
+			for name, refs := range test.expected {
+				if got := r.Refs(hashes[name]); strings.Join(got, ",") != strings.Join(refs, ",") {
+					t.Errorf("expected %s to be in %v, got %v", name, refs, got)
+				}
+			}
+		})
+	}
+}
+
+// failingRefsStorage fails while listing the references
+type failingRefsStorage struct {
+	*memory.Storage
+	err error
+}
+
+func (s *failingRefsStorage) IterReferences() (storer.ReferenceIter, error) {
+	iter, err := s.Storage.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+	return &failingRefIter{ReferenceIter: iter, err: s.err}, nil
+}
+
+type failingRefIter struct {
+	storer.ReferenceIter
+	err error
+}
+
+func (i *failingRefIter) ForEach(fn func(*plumbing.Reference) error) error {
+	return i.err
+}
+
+func TestWalkCommitsRefsError(t *testing.T) {
+	repo, _ := newTestRepo(t)
+	broken := errors.New("broken packed-refs")
+	storer, err := git.Open(&failingRefsStorage{Storage: repo.Storer.Storer.(*memory.Storage), err: broken}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Repo{Storer: storer, AllRefs: true}
+	if _, err := r.FetchCommits(); !errors.Is(err, broken) {
+		t.Errorf("expected the error listing the refs, got %v", err)
+	}
+}
+
+func TestWalkCommitsStop(t *testing.T) {
+	repo, hashes := newTestRepo(t)
+	r := &Repo{Storer: repo.Storer, AllRefs: true}
+
+	stop := errors.New("stop")
+	walked := []plumbing.Hash{}
+	err := r.WalkCommits(func(commit *object.Commit) error {
+		walked = append(walked, commit.Hash)
+		if len(walked) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Fatalf("expected the error of the callback, got %v", err)
+	}
+	if len(walked) != 2 {
+		t.Errorf("expected the walk to stop after 2 commits, got %d", len(walked))
+	}
+	// Refs are resolved when asked for, for every commit walked or not
+	if len(r.refs) != 0 {
+		t.Errorf("expected no refs to be resolved by the walk, got %v", r.refs)
+	}
+	if refs := r.Refs(hashes["c0"]); len(refs) == 0 {
+		t.Errorf("expected the refs of c0 to be known, got %v", refs)
+	}
+}
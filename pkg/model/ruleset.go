@@ -2,13 +2,16 @@ package model
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/hex"
-	"hash/fnv"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -18,7 +21,6 @@ import (
 	"github.com/gobuffalo/packr/v2"
 	"github.com/mholt/archiver/v3"
 	"github.com/rs/zerolog/log"
-	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -33,28 +35,41 @@ const (
 //   it can parse a file line by line to precisely find the leak
 // - Parsers are parsers that need the entire file as a context
 //   to analyse for leaks correctly (TODO: rename)
+// - EntropyRules are context independant detectors that flag
+//   random looking tokens without relying on a known format
 //
 type RuleSet struct {
-	// Version of the configuration file
-	// Not used currently but for future proofing
+	// Version of the schema of the configuration file as declared by the
+	// file (see APIVersions), older versions are migrated when parsed
 	APIVersion string `yaml:"apiVersion"`
 
-	// FNV hash of the configuration file
-	// Useful for determining whether or not the definition file
-	// has been changed. (for future uses)
-	Checksum          string
-	ReadAt            time.Time
+	// SHA-256 of the configuration file
+	// Used for determining whether or not the definition file
+	// has been changed (e.g. invalidating the scan cache)
+	Checksum string `yaml:"-"`
+	// Path of the configuration file, empty for the embedded rules
+	Path              string    `yaml:"-"`
+	ReadAt            time.Time `yaml:"-"`
 	IndepParsers      []IndepParserRule `yaml:"rules"`
 	CtxParsers        []CtxParserRule   `yaml:"parsers"`
+	EntropyRules      []EntropyRule     `yaml:"entropy"`
 	BlackList         []string          `yaml:"black_list"`
 	BlackListCompiled []*regexp.Regexp  `yaml:"-"`
+	// Findings which are known not to be leaks
+	Allow []AllowRule `yaml:"allow"`
+	// Findings suppressed by pragmas or the allow section during the scans
+	Suppressed Suppressions `yaml:"-"`
+	// Root of the scanned directory, the paths of the allow section
+	// are matched relative to it (empty for git scans)
+	Root string `yaml:"-"`
 
 	// Sets whether or not to examine compressed files
 	Compressed bool `yaml:"compressed"`
 }
 
 // ParseConfig reads the user defined configuration file
-func (r *RuleSet) ParseConfig(file string) {
+// (the embedded rules if the file is empty) and compiles its rules
+func (r *RuleSet) ParseConfig(file string) error {
 	var data []byte
 	var err error
 
@@ -62,97 +77,360 @@ func (r *RuleSet) ParseConfig(file string) {
 		box := packr.New("rules", "../../resources")
 		data, err = box.Find("rules.yaml")
 		if err != nil {
-			log.Fatal().
-				Str("path", file).
-				Err(err).
-				Msg("Failed to read static binary definition")
+			return fmt.Errorf("failed to read static binary definition: %w", err)
 		}
 	} else {
 		data, err = ioutil.ReadFile(file)
 		if err != nil {
-			log.Fatal().
-				Str("path", file).
-				Err(err).
-				Msg("Failed to read rules definition @")
+			return fmt.Errorf("failed to read rules definition: %w", err)
 		}
 	}
-	if err := yaml.Unmarshal(data, &r); err != nil {
-		log.Fatal().
-			Str("path", file).
-			Err(err).
-			Msg("Failed to unmarshal yaml @")
+	doc, version, err := MigrateRules(data)
+	if err != nil {
+		return fmt.Errorf("failed to read rules %s: %w", file, err)
 	}
-	r.Checksum = hex.EncodeToString(fnv.New32().Sum(data))[:10]
+	if err := doc.Decode(r); err != nil {
+		return fmt.Errorf("failed to unmarshal rules %s: %w", file, err)
+	}
+	sum := sha256.Sum256(data)
+	r.APIVersion = version
+	r.Checksum = hex.EncodeToString(sum[:])
+	r.Path = file
 	r.ReadAt = time.Now()
 
 	for idx, rule := range r.IndepParsers {
-		r.IndepParsers[idx].Compiled = regexp.MustCompile(rule.Definition)
+		if r.IndepParsers[idx].Compiled, err = regexp.Compile(rule.Definition); err != nil {
+			return fmt.Errorf("invalid rule %q: %w", rule.Definition, err)
+		}
 	}
 	for idx := range r.CtxParsers {
-		r.CtxParsers[idx].Init()
+		if err := r.CtxParsers[idx].Init(); err != nil {
+			return err
+		}
+	}
+	for idx := range r.EntropyRules {
+		if err := r.EntropyRules[idx].Init(); err != nil {
+			return err
+		}
+	}
+	for idx := range r.Allow {
+		if err := r.Allow[idx].Init(); err != nil {
+			return err
+		}
 	}
 
 	for _, bl := range r.BlackList {
-		r.BlackListCompiled = append(r.BlackListCompiled, regexp.MustCompile(bl))
+		compiled, err := regexp.Compile(bl)
+		if err != nil {
+			return fmt.Errorf("invalid black list regex %q: %w", bl, err)
+		}
+		r.BlackListCompiled = append(r.BlackListCompiled, compiled)
 	}
+	return nil
 }
 
-// ParsePatch iterates over each chunk of the patch object
-// and applies all context indenpendant rules
-// TODO: allow context dependant rules
+// ParsePatch iterates over each file of the patch object
+// and applies all context indenpendant rules to the added lines.
+// Files handled by a context parser are parsed as a whole instead.
 //
 func (r *RuleSet) ParsePatch(patch *object.Patch, commit *object.Commit, repo *Repo, leakChan chan Leak) {
 	for _, filePatch := range patch.FilePatches() {
 		if filePatch.IsBinary() {
-			break
+			continue
 		}
 		_, to := filePatch.Files()
-		if to == nil {
+		if to == nil || r.isBlackListed(to.Path()) {
+			continue
+		}
+
+		lines, added := PatchLines(filePatch)
+		r.parseLines(to.Path(), lines, added, func(found FileLeak) {
+			leakChan <- newGitLeak(found, commit, repo, to.Hash(), to.Path())
+		})
+	}
+}
+
+// ParseAdditions applies the rules to the lines of the file flagged as added
+// (e.g. the staged changes of a file) and sends the leaks as FileLeaks.
+// The lines are the whole content of the file so that context parsers
+// and pragmas see the unchanged lines surrounding the additions.
+func (r *RuleSet) ParseAdditions(file string, lines []string, added []bool, leakChan chan Leak) {
+	if r.isBlackListed(file) {
+		return
+	}
+	r.parseLines(file, lines, added, func(found FileLeak) {
+		leakChan <- found
+	})
+}
+
+// parseLines applies the rules to the lines of the file which are added
+// (all of them if added is nil) and emits the leaks which are not allowed.
+// Context parsers are run on the whole file and their leaks on lines
+// which are not added are discarded (the rest of the file is the context).
+func (r *RuleSet) parseLines(file string, lines []string, added []bool, emit func(FileLeak)) {
+	isAdded := func(idx int) bool {
+		return added == nil || (idx >= 0 && idx < len(added) && added[idx])
+	}
+
+	if rule := r.ctxParser(file); rule != nil {
+		fileChan := make(chan Leak)
+		go func() {
+			rule.Parser.Parse(strings.NewReader(strings.Join(lines, "\n")), fileChan, file, rule)
+			close(fileChan)
+		}()
+		for leak := range fileChan {
+			found, ok := leak.(FileLeak)
+			if !ok || !isAdded(found.Line-1) {
+				continue
+			}
+			if r.allowed(found.Rule(), file, lines, found.Line-1, found.Match()) {
+				continue
+			}
+			emit(found)
+		}
+		return
+	}
+
+	// Multi-line rules are applied to the whole content, their leaks are
+	// reported if one of their lines is added and the lines they cover
+	// are not matched line by line
+	multi := map[int][]multilineMatch{}
+	covered := map[int]bool{}
+	for _, m := range r.matchMultiline(lines) {
+		added := false
+		for idx := m.line; idx <= m.endLine; idx++ {
+			covered[idx] = true
+			added = added || isAdded(idx)
+		}
+		if added {
+			multi[m.line] = append(multi[m.line], m)
+		}
+	}
+
+	for idx, line := range lines {
+		for _, m := range multi[idx] {
+			if r.allowed(m.info(), file, lines, idx, match(lines, m.line, m.endLine, m.start, m.end)) {
+				continue
+			}
+			start, _ := snippetBounds(m.line, len(lines))
+			_, end := snippetBounds(m.endLine, len(lines))
+			disc := FileLeak{
+				File:            file,
+				StartIdx:        m.start,
+				EndLine:         m.endLine + 1,
+				EndIdx:          m.end,
+				Line:            idx + 1,
+				Affected:        idx - start,
+				IndepParserRule: m.indepRule,
+				Confidence:      m.confidence,
+			}
+			disc.Snippet = make([]string, len(lines[start:end]))
+			copy(disc.Snippet, lines[start:end])
+			emit(disc)
+		}
+		if covered[idx] || !isAdded(idx) || !utf8.ValidString(line) {
+			continue
+		}
+		for _, match := range r.matchLine(line) {
+			if r.allowed(match.info(), file, lines, idx, line[match.start:match.end]) {
+				continue
+			}
+			start, end := snippetBounds(idx, len(lines))
+			disc := FileLeak{
+				File:            file,
+				StartIdx:        match.start,
+				EndIdx:          match.end,
+				Line:            idx + 1,
+				Affected:        idx - start,
+				IndepParserRule: match.indepRule,
+				EntropyRule:     match.entropyRule,
+				Entropy:         match.entropy,
+				Confidence:      match.confidence,
+			}
+			disc.Snippet = make([]string, len(lines[start:end]))
+			copy(disc.Snippet, lines[start:end])
+			emit(disc)
+		}
+	}
+}
+
+// PatchLines rebuilds the content of the file once the patch is applied
+// from its unchanged and added chunks, the second slice flags
+// which of these lines were added by the patch.
+//
+// The index of a line is therefore its line number (starting from 0)
+// in the new version of the file.
+func PatchLines(filePatch diff.FilePatch) ([]string, []bool) {
+	lines := []string{}
+	added := []bool{}
+	for _, chunk := range filePatch.Chunks() {
+		if chunk.Type() == diff.Delete {
+			continue
+		}
+		content := strings.Split(chunk.Content(), "\n")
+		// Chunks end with a new line except for the end of the file
+		if len(content) > 0 && content[len(content)-1] == "" {
+			content = content[:len(content)-1]
+		}
+		for _, line := range content {
+			lines = append(lines, line)
+			added = append(added, chunk.Type() == diff.Add)
+		}
+	}
+	return lines, added
+}
+
+// lineMatch is a part of a line flagged by a context independant rule
+type lineMatch struct {
+	start       int
+	end         int
+	confidence  string
+	entropy     float64
+	indepRule   *IndepParserRule
+	entropyRule *EntropyRule
+}
+
+// info returns the description of the rule of the match
+func (m lineMatch) info() RuleInfo {
+	return ruleInfo(m.indepRule, nil, m.entropyRule)
+}
+
+// multilineMatch is a part of the content flagged by a multi-line rule,
+// the start and end indexes are relative to its first and last lines
+type multilineMatch struct {
+	lineMatch
+	// Indexes of the first and last lines of the match
+	line, endLine int
+}
+
+// matchMultiline applies the multi-line rules to the lines joined
+// together and converts the offsets of the matches to line indexes
+func (r *RuleSet) matchMultiline(lines []string) []multilineMatch {
+	var res []multilineMatch
+	var content string
+	var offsets []int
+	// position returns the index of the line of the offset
+	// and the offset from the beginning of that line
+	position := func(offset int) (int, int) {
+		idx := sort.Search(len(offsets), func(i int) bool {
+			return offsets[i] > offset
+		}) - 1
+		return idx, offset - offsets[idx]
+	}
+
+	for i := range r.IndepParsers {
+		rule := &r.IndepParsers[i]
+		if !rule.Multiline {
 			continue
 		}
-		for _, blacklist := range r.BlackListCompiled {
-			if blacklist.MatchString(to.Path()) {
-				break
+		if offsets == nil {
+			content = strings.Join(lines, "\n")
+			offsets = make([]int, len(lines))
+			for idx := 1; idx < len(lines); idx++ {
+				offsets[idx] = offsets[idx-1] + len(lines[idx-1]) + 1
+			}
+		}
+		for _, m := range rule.Compiled.FindAllStringIndex(content, -1) {
+			if m[0] == m[1] || !utf8.ValidString(content[m[0]:m[1]]) {
+				continue
+			}
+			line, start := position(m[0])
+			endLine, end := position(m[1] - 1)
+			// Matches ending with a new line end at the end of the line
+			if end++; end > len(lines[endLine]) {
+				end = len(lines[endLine])
 			}
+			res = append(res, multilineMatch{
+				lineMatch: lineMatch{
+					start:      start,
+					end:        end,
+					confidence: "Low",
+					indepRule:  rule,
+				},
+				line:    line,
+				endLine: endLine,
+			})
 		}
+	}
+	return res
+}
+
+// matchLine applies the context independant rules to the line.
+// Only the first matching regex rule is reported and high entropy tokens
+// are only looked for if no regex rule matched since the latter is more precise
+func (r *RuleSet) matchLine(line string) []lineMatch {
+	for i := range r.IndepParsers {
+		rule := &r.IndepParsers[i]
+		if rule.Multiline {
+			continue
+		}
+		if match := rule.Compiled.FindStringIndex(line); match != nil {
+			return []lineMatch{{
+				start:      match[0],
+				end:        match[1],
+				confidence: "Low",
+				indepRule:  rule,
+			}}
+		}
+	}
 
-		for _, chunk := range filePatch.Chunks() {
-			if chunk.Type() == diff.Add {
-				lines := strings.Split(chunk.Content(), "\n")
-				for idx, line := range lines {
-					for _, rule := range r.IndepParsers {
-						match := rule.Compiled.FindStringIndex(line)
-						if len(match) > 0 {
-							start := idx - contextSize
-							end := idx + contextSize
-							if start < 0 {
-								start = 0
-							}
-							if end >= len(lines) {
-								end = len(lines) - 1
-							}
-							disc := GitLeak{
-								Line:            idx,
-								Affected:        idx - start,
-								File:            to.Path(),
-								StartIdx:        match[0],
-								EndIdx:          match[1],
-								Author:          commit.Author.Name,
-								When:            commit.Author.When,
-								Commit:          to.Hash().String(),
-								Repo:            repo,
-								IndepParserRule: &rule,
-							}
-							disc.Snippet = make([]string, len(lines[start:end]))
-							copy(disc.Snippet, lines[start:end])
-							leakChan <- disc
-							break
-						}
-					}
-				}
+	for i := range r.EntropyRules {
+		rule := &r.EntropyRules[i]
+		matches := rule.Find(line)
+		if len(matches) == 0 {
+			continue
+		}
+		res := make([]lineMatch, len(matches))
+		for j, match := range matches {
+			res[j] = lineMatch{
+				start:       match.start,
+				end:         match.end,
+				confidence:  rule.Confidence,
+				entropy:     match.entropy,
+				entropyRule: rule,
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// ctxParser returns the context parser rule handling the file
+// or nil if the file should be parsed line by line
+func (r *RuleSet) ctxParser(filename string) *CtxParserRule {
+	for idx := range r.CtxParsers {
+		for _, ext := range r.CtxParsers[idx].Extensions {
+			if strings.HasSuffix(filename, ext) {
+				return &r.CtxParsers[idx]
 			}
 		}
 	}
+	return nil
+}
+
+// isBlackListed checks whether or not a path matches
+// one of the black listed regexes
+func (r *RuleSet) isBlackListed(path string) bool {
+	for _, blacklist := range r.BlackListCompiled {
+		if blacklist.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// snippetBounds returns the [start, end) indexes of the lines
+// surrounding the offending line that are added to the snippet
+func snippetBounds(idx, n int) (int, int) {
+	start := idx - contextSize
+	end := idx + contextSize + 1
+	if start < 0 {
+		start = 0
+	}
+	if end > n {
+		end = n
+	}
+	return start, end
 }
 
 func (r *RuleSet) parseArchive(file *os.File, leakChan chan Leak) {
@@ -167,51 +445,11 @@ func (r *RuleSet) parseArchive(file *os.File, leakChan chan Leak) {
 }
 
 func (r *RuleSet) parseRegular(file io.Reader, filename string, leakChan chan Leak) {
-	// Check for blacklisted file names
-	for _, rule := range r.CtxParsers {
-		for _, ext := range rule.Extensions {
-			if strings.HasSuffix(filename, ext) {
-				rule.Parser.Parse(file, leakChan, filename, &rule)
-				return
-			}
-		}
-	}
-
 	buf := &bytes.Buffer{}
 	buf.ReadFrom(file)
-	lines := strings.Split(buf.String(), "\n")
-	for idx, line := range lines {
-		if !utf8.ValidString(line) {
-			continue
-		}
-		for _, rule := range r.IndepParsers {
-			match := rule.Compiled.FindStringIndex(line)
-
-			if len(match) > 0 {
-				start := idx - contextSize
-				end := idx + contextSize
-				if start < 0 {
-					start = 0
-				}
-				if end >= len(lines) {
-					end = len(lines) - 1
-				}
-				disc := FileLeak{
-					File:            filename,
-					StartIdx:        match[0],
-					EndIdx:          match[1],
-					Line:            idx,
-					Affected:        idx - start,
-					IndepParserRule: &rule,
-					Confidence:      "Low",
-				}
-				disc.Snippet = make([]string, len(lines[start:end]))
-				copy(disc.Snippet, lines[start:end])
-				leakChan <- disc
-				break
-			}
-		}
-	}
+	r.parseLines(filename, strings.Split(buf.String(), "\n"), nil, func(found FileLeak) {
+		leakChan <- found
+	})
 }
 
 // Parse reads a given file and applies all rules given
@@ -234,3 +472,47 @@ func (r *RuleSet) Parse(file string, leakChan chan Leak) {
 		r.parseRegular(fd, file, leakChan)
 	}
 }
+
+// RuleRef identifies the rule of a leak by its position in the rule set
+// ("rules/0", "parsers/2", "entropy/1"), the reference is only valid
+// for rule sets with the same checksum (see ResolveRule)
+func (r *RuleSet) RuleRef(indep *IndepParserRule, ctx *CtxParserRule, entropy *EntropyRule) string {
+	for idx := range r.IndepParsers {
+		if indep == &r.IndepParsers[idx] {
+			return fmt.Sprintf("rules/%d", idx)
+		}
+	}
+	for idx := range r.CtxParsers {
+		if ctx == &r.CtxParsers[idx] {
+			return fmt.Sprintf("parsers/%d", idx)
+		}
+	}
+	for idx := range r.EntropyRules {
+		if entropy == &r.EntropyRules[idx] {
+			return fmt.Sprintf("entropy/%d", idx)
+		}
+	}
+	return ""
+}
+
+// ResolveRule returns the rule referenced by RuleRef, only one
+// of the returned rules is set and false if the rule does not exist
+func (r *RuleSet) ResolveRule(ref string) (*IndepParserRule, *CtxParserRule, *EntropyRule, bool) {
+	kind := ""
+	idx := -1
+	if sep := strings.IndexByte(ref, '/'); sep != -1 {
+		kind = ref[:sep]
+		if n, err := strconv.Atoi(ref[sep+1:]); err == nil {
+			idx = n
+		}
+	}
+	switch {
+	case kind == "rules" && idx >= 0 && idx < len(r.IndepParsers):
+		return &r.IndepParsers[idx], nil, nil, true
+	case kind == "parsers" && idx >= 0 && idx < len(r.CtxParsers):
+		return nil, &r.CtxParsers[idx], nil, true
+	case kind == "entropy" && idx >= 0 && idx < len(r.EntropyRules):
+		return nil, nil, &r.EntropyRules[idx], true
+	}
+	return nil, nil, nil, false
+}
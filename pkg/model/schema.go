@@ -0,0 +1,128 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Versions of the schema of the rules definition file
+const (
+	// APIVersionV1 is the original schema
+	APIVersionV1 = "v1"
+	// LatestAPIVersion is the version the rules are migrated to
+	LatestAPIVersion = APIVersionV1
+)
+
+// migration upgrades a rules document to the next version of the schema
+type migration struct {
+	next    string
+	migrate func(doc *yaml.Node) error
+}
+
+// migrations of each version of the schema to the next one, the rules are
+// migrated step by step until they follow the latest version. A new version
+// of the schema (e.g. v2) adds the migration of the previous one to this map
+// and becomes the LatestAPIVersion.
+var migrations = map[string]migration{}
+
+// aliases of the keys of the latest schema, they are accepted
+// in place of the key and renamed when the rules are parsed
+var aliases = map[string]string{
+	"exclude": "black_list",
+}
+
+// APIVersions returns the supported versions of the schema
+func APIVersions() []string {
+	versions := []string{LatestAPIVersion}
+	for version := range migrations {
+		versions = append(versions, version)
+	}
+	// "v1" < "v2" < "v10"
+	sort.Slice(versions, func(i, j int) bool {
+		if len(versions[i]) != len(versions[j]) {
+			return len(versions[i]) < len(versions[j])
+		}
+		return versions[i] < versions[j]
+	})
+	return versions
+}
+
+// MigrateRules parses a rules definition file and migrates it to the latest
+// version of the schema, the version declared by the file is returned.
+// Unknown versions are rejected and a missing version is considered as v1.
+// Aliased keys are renamed to the key of the schema.
+func MigrateRules(data []byte) (*yaml.Node, string, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, "", err
+	}
+	if doc.Kind == 0 {
+		// Empty file
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, "", fmt.Errorf("rules must be a mapping")
+	}
+
+	declared := APIVersionV1
+	if version := apiVersionNode(doc); version != nil {
+		declared = version.Value
+	}
+	for version := declared; version != LatestAPIVersion; {
+		step, ok := migrations[version]
+		if !ok {
+			return nil, declared, fmt.Errorf("unsupported apiVersion %q, must be one of (%s)", declared, strings.Join(APIVersions(), ", "))
+		}
+		if err := step.migrate(doc); err != nil {
+			return nil, declared, fmt.Errorf("unable to migrate rules from %s to %s: %w", version, step.next, err)
+		}
+		version = step.next
+	}
+	for alias, key := range aliases {
+		idx := mappingKey(doc, alias)
+		if idx == -1 {
+			continue
+		}
+		if mappingKey(doc, key) != -1 {
+			return nil, declared, fmt.Errorf("%s is an alias of %s, only one of them can be set", alias, key)
+		}
+		doc.Content[0].Content[idx].Value = key
+	}
+	return doc, declared, nil
+}
+
+// SetAPIVersion sets the apiVersion of the rules document
+func SetAPIVersion(doc *yaml.Node, version string) {
+	if node := apiVersionNode(doc); node != nil {
+		node.Value = version
+		return
+	}
+	mapping := doc.Content[0]
+	mapping.Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: "apiVersion"},
+		{Kind: yaml.ScalarNode, Tag: "!!str", Value: version},
+	}, mapping.Content...)
+}
+
+// apiVersionNode returns the value of the apiVersion key if any
+func apiVersionNode(doc *yaml.Node) *yaml.Node {
+	if idx := mappingKey(doc, "apiVersion"); idx != -1 {
+		return doc.Content[0].Content[idx+1]
+	}
+	return nil
+}
+
+// mappingKey returns the index of the key within the top level mapping
+// of the rules document, -1 if the key is missing
+func mappingKey(doc *yaml.Node, key string) int {
+	mapping := doc.Content[0]
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
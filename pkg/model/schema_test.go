@@ -0,0 +1,75 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMigrateRules(t *testing.T) {
+	tests := []struct {
+		rules    string
+		declared string
+		err      bool
+	}{
+		{"apiVersion: v1\nrules: []\n", APIVersionV1, false},
+		{"rules: []\n", APIVersionV1, false},
+		{"", APIVersionV1, false},
+		{"apiVersion: v2\n", "v2", true},
+		{"- rules\n", "", true},
+		{"apiVersion: v1\nexclude: []\nblack_list: []\n", APIVersionV1, true},
+	}
+	for _, test := range tests {
+		doc, declared, err := MigrateRules([]byte(test.rules))
+		if (err != nil) != test.err {
+			t.Errorf("%q: unexpected error %v", test.rules, err)
+			continue
+		}
+		if declared != test.declared {
+			t.Errorf("%q: expected version %s, got %s", test.rules, test.declared, declared)
+		}
+		if err != nil {
+			continue
+		}
+		SetAPIVersion(doc, LatestAPIVersion)
+		data, err := yaml.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), "apiVersion: "+LatestAPIVersion+"\n") {
+			t.Errorf("%q: expected the migrated rules to declare %s, got %s", test.rules, LatestAPIVersion, data)
+		}
+	}
+
+	// exclude is an alias of black_list
+	for _, rules := range []string{
+		"apiVersion: v1\nblack_list:\n  - '.*sample.*'\n",
+		"black_list:\n  - '.*sample.*'\n",
+		"apiVersion: v1\nexclude:\n  - '.*sample.*'\n",
+	} {
+		doc, _, err := MigrateRules([]byte(rules))
+		if err != nil {
+			t.Fatal(err)
+		}
+		migrated := RuleSet{}
+		if err := doc.Decode(&migrated); err != nil {
+			t.Fatal(err)
+		}
+		if len(migrated.BlackList) != 1 || migrated.BlackList[0] != ".*sample.*" {
+			t.Errorf("%q: expected the excluded files to be parsed, got %v", rules, migrated.BlackList)
+		}
+		data, _ := yaml.Marshal(doc)
+		if strings.Contains(string(data), "exclude") {
+			t.Errorf("%q: expected exclude to be renamed black_list, got %s", rules, data)
+		}
+	}
+
+	rs := RuleSet{}
+	rs.ParseConfig("../../resources/rules.yaml")
+	other := RuleSet{}
+	other.ParseConfig("")
+	if len(rs.Checksum) != 64 || rs.Checksum != other.Checksum {
+		t.Errorf("expected the checksum of the embedded rules to be the sha256 of the file, got %q and %q", rs.Checksum, other.Checksum)
+	}
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/binary"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// StagedFile is a file of the index which differs from HEAD
+type StagedFile struct {
+	// Path of the file from the root of the repository
+	Path string
+	// Hash of the staged blob
+	Blob string
+	// Content of the staged file, the second slice flags which
+	// of its lines are not in the version of HEAD (see PatchLines)
+	Lines []string
+	Added []bool
+}
+
+// StagedFiles diffs the index against HEAD and returns the staged
+// text files which have been added or modified. Deleted, binary files
+// and submodules are skipped as they can not introduce leaks.
+//
+// Repositories without any commit are considered as having
+// an empty HEAD, all staged lines are therefore added.
+func (r *Repo) StagedFiles() ([]StagedFile, error) {
+	idx, err := r.Storer.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	var tree *object.Tree
+	head, err := r.Storer.Head()
+	switch err {
+	case nil:
+		commit, err := r.Storer.CommitObject(head.Hash())
+		if err != nil {
+			return nil, err
+		}
+		if tree, err = commit.Tree(); err != nil {
+			return nil, err
+		}
+	case plumbing.ErrReferenceNotFound:
+	default:
+		return nil, err
+	}
+
+	files := []StagedFile{}
+	for _, entry := range idx.Entries {
+		// Unmerged entries have a stage from 1 to 3 (index.Merged
+		// is misleadingly defined as 1 by go-git), merged ones 0
+		if entry.Stage != 0 || entry.IntentToAdd || !entry.Mode.IsFile() || entry.Mode == filemode.Symlink {
+			continue
+		}
+
+		before := ""
+		if tree != nil {
+			file, err := tree.File(entry.Name)
+			switch err {
+			case nil:
+				if file.Hash == entry.Hash {
+					continue
+				}
+				if before, err = blobContent(r, file.Hash); err != nil {
+					return nil, err
+				}
+			case object.ErrFileNotFound:
+			default:
+				return nil, err
+			}
+		}
+
+		after, err := blobContent(r, entry.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if isBinary, _ := binary.IsBinary(strings.NewReader(after)); isBinary {
+			continue
+		}
+
+		lines, added := diffLines(before, after)
+		files = append(files, StagedFile{
+			Path:  entry.Name,
+			Blob:  entry.Hash.String(),
+			Lines: lines,
+			Added: added,
+		})
+	}
+	return files, nil
+}
+
+// blobContent reads the content of the blob
+func blobContent(r *Repo, hash plumbing.Hash) (string, error) {
+	blob, err := r.Storer.BlobObject(hash)
+	if err != nil {
+		return "", err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	if len(content) == 0 {
+		return "", nil
+	}
+	// A missing new line at the end of the file would otherwise
+	// flag its last line as modified once a line is appended
+	return string(bytes.TrimSuffix(content, []byte("\n"))) + "\n", nil
+}
+
+// diffLines returns the lines of the new version of the text
+// and flags which of them are not in the old one
+func diffLines(before, after string) ([]string, []bool) {
+	lines := []string{}
+	added := []bool{}
+	for _, d := range diff.Do(before, after) {
+		if d.Type == diffmatchpatch.DiffDelete {
+			continue
+		}
+		content := strings.Split(d.Text, "\n")
+		// Diffs end with a new line except for the end of the file
+		if len(content) > 0 && content[len(content)-1] == "" {
+			content = content[:len(content)-1]
+		}
+		for _, line := range content {
+			lines = append(lines, line)
+			added = append(added, d.Type == diffmatchpatch.DiffInsert)
+		}
+	}
+	return lines, added
+}
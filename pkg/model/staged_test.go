@@ -0,0 +1,63 @@
+package model
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ichbinfrog/excavator/internal/gittest"
+)
+
+func TestStagedFiles(t *testing.T) {
+	fixture := gittest.NewRepo(t)
+	write := func(file string, lines ...string) {
+		fixture.Stage(file, strings.Join(lines, "\n")+"\n")
+	}
+
+	repo := &Repo{Storer: fixture.Repository}
+	write("app.conf", "a", "b")
+	files, err := repo.StagedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "app.conf" || strings.Join(files[0].Lines, " ") != "a b" || !files[0].Added[0] || !files[0].Added[1] {
+		t.Fatalf("expected app.conf to be added in an empty repository, got %+v", files)
+	}
+
+	fixture.Commit("init", time.Now())
+	write("app.conf", "a", "aws_key = AKIA1234567890ABCDEF", "b")
+	write("unchanged.conf", "c")
+	fixture.Commit("second", time.Now())
+	write("app.conf", "a", "aws_key = AKIA1234567890ABCDEF", "b", "aws_key = AKIA0000000000000000")
+	fixture.WriteFile("unstaged.conf", "aws_key = AKIA9999999999999999\n")
+
+	files, err = repo.StagedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Path != "app.conf" {
+		t.Fatalf("expected only app.conf to be staged, got %+v", files)
+	}
+
+	rs := RuleSet{
+		IndepParsers: []IndepParserRule{{
+			Definition: "AKIA[0-9A-Z]{16}",
+			Compiled:   regexp.MustCompile("AKIA[0-9A-Z]{16}"),
+		}},
+	}
+	leakChan := make(chan Leak)
+	go func() {
+		for _, file := range files {
+			rs.ParseAdditions(file.Path, file.Lines, file.Added, leakChan)
+		}
+		close(leakChan)
+	}()
+	leaks := []FileLeak{}
+	for leak := range leakChan {
+		leaks = append(leaks, leak.(FileLeak))
+	}
+	if len(leaks) != 1 || leaks[0].Line != 4 || leaks[0].Match() != "AKIA0000000000000000" {
+		t.Errorf("expected the staged token on line 4, got %+v", leaks)
+	}
+}
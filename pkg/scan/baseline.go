@@ -0,0 +1,224 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+)
+
+// BaselineVersion is the version of the format of the baseline file
+const BaselineVersion = "1"
+
+// Baseline is a list of known findings which are not reported
+// by the scans (findings that have been reviewed, false positives, ...)
+type Baseline struct {
+	Version string          `json:"version"`
+	Entries []BaselineEntry `json:"entries"`
+
+	// Path the baseline was loaded from
+	Path string `json:"-"`
+	// Root of the scan, files are stored relatively to it
+	Root string `json:"-"`
+
+	mu         sync.Mutex
+	index      map[baselineKey][]int
+	matched    []bool
+	suppressed int
+}
+
+// BaselineEntry identifies a known finding
+type BaselineEntry struct {
+	// Identifier of the rule which detected the finding
+	Rule string `json:"rule" yaml:"rule"`
+	// Path of the file relative to the root of the scan
+	File string `json:"file" yaml:"file"`
+	// Hash of the offending value once trimmed of its spaces and quotes
+	Hash string `json:"hash" yaml:"hash"`
+	// Commit which introduced the finding (git scans only)
+	Commit string `json:"commit,omitempty" yaml:"commit,omitempty"`
+}
+
+// BaselineSummary is the effect of the baseline on the scan
+type BaselineSummary struct {
+	// Path of the baseline file
+	File string `json:"file" yaml:"file"`
+	// Amount of findings of the baseline which were not reported
+	Suppressed int `json:"suppressed" yaml:"suppressed"`
+	// Entries of the baseline which were not found by the scan
+	Stale []BaselineEntry `json:"stale" yaml:"stale"`
+}
+
+// BaselineReport implements the ReportInterface to write
+// the findings of the scan as a baseline
+type BaselineReport struct {
+	Outfile string
+}
+
+type baselineKey struct {
+	rule, file, hash string
+}
+
+// newBaselineEntry returns the entry identifying the leak
+// found by a scan of the given root
+func newBaselineEntry(leak model.Leak, root string) (BaselineEntry, bool) {
+	switch disc := leak.(type) {
+	case model.FileLeak:
+		return BaselineEntry{
+			Rule: disc.Rule().ID,
+			File: model.RelativePath(root, disc.File),
+			Hash: disc.MatchHash(),
+		}, true
+	case model.GitLeak:
+		return BaselineEntry{
+			Rule:   disc.Rule().ID,
+			File:   disc.File,
+			Hash:   disc.MatchHash(),
+			Commit: disc.Commit,
+		}, true
+	}
+	return BaselineEntry{}, false
+}
+
+// NewBaseline creates a baseline of the leaks found by a scan of the
+// given root (empty for git scans which files are relative already)
+func NewBaseline(leaks []model.Leak, root string) *Baseline {
+	seen := map[BaselineEntry]bool{}
+	b := &Baseline{
+		Version: BaselineVersion,
+		Entries: []BaselineEntry{},
+		Root:    root,
+	}
+	for _, leak := range leaks {
+		entry, ok := newBaselineEntry(leak, root)
+		if !ok || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		b.Entries = append(b.Entries, entry)
+	}
+	sort.Slice(b.Entries, func(i, j int) bool {
+		x, y := b.Entries[i], b.Entries[j]
+		if x.File != y.File {
+			return x.File < y.File
+		}
+		if x.Rule != y.Rule {
+			return x.Rule < y.Rule
+		}
+		if x.Hash != y.Hash {
+			return x.Hash < y.Hash
+		}
+		return x.Commit < y.Commit
+	})
+	b.init()
+	return b
+}
+
+// LoadBaseline reads a baseline file
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read baseline %s: %w", path, err)
+	}
+	b := &Baseline{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("unable to parse baseline %s: %w", path, err)
+	}
+	if b.Version != BaselineVersion {
+		return nil, fmt.Errorf("unsupported baseline version %q in %s, must be %s", b.Version, path, BaselineVersion)
+	}
+	b.Path = path
+	b.init()
+	return b, nil
+}
+
+func (b *Baseline) init() {
+	b.index = map[baselineKey][]int{}
+	b.matched = make([]bool, len(b.Entries))
+	for idx, entry := range b.Entries {
+		key := baselineKey{entry.Rule, entry.File, entry.Hash}
+		b.index[key] = append(b.index[key], idx)
+	}
+}
+
+// Suppress returns whether or not the leak is a known finding.
+//
+// Entries recorded by a git scan also need the commit to match
+// whereas entries without commit match the leak in any commit.
+// It is safe for concurrent use and nil baselines suppress nothing.
+func (b *Baseline) Suppress(leak model.Leak) bool {
+	if b == nil {
+		return false
+	}
+	found, ok := newBaselineEntry(leak, b.Root)
+	if !ok {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	suppressed := false
+	for _, idx := range b.index[baselineKey{found.Rule, found.File, found.Hash}] {
+		entry := b.Entries[idx]
+		if entry.Commit == "" || found.Commit == "" || entry.Commit == found.Commit {
+			b.matched[idx] = true
+			suppressed = true
+		}
+	}
+	if suppressed {
+		b.suppressed++
+	}
+	return suppressed
+}
+
+// Suppressed returns the amount of leaks suppressed by the baseline
+func (b *Baseline) Suppressed() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.suppressed
+}
+
+// Stale returns the entries which did not match any leak
+// (the finding has been removed or the file has been renamed)
+func (b *Baseline) Stale() []BaselineEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stale := []BaselineEntry{}
+	for idx, entry := range b.Entries {
+		if !b.matched[idx] {
+			stale = append(stale, entry)
+		}
+	}
+	return stale
+}
+
+// Summary returns the effect of the baseline on the scan
+func (b *Baseline) Summary() *BaselineSummary {
+	if b == nil {
+		return nil
+	}
+	return &BaselineSummary{
+		File:       b.Path,
+		Suppressed: b.Suppressed(),
+		Stale:      b.Stale(),
+	}
+}
+
+func (r BaselineReport) Write(s Scanner) error {
+	if r.Outfile == "" {
+		r.Outfile = ".excavator-baseline.json"
+	}
+	return writeOutput(r.Outfile, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		root := ""
+		if rs := s.Rules(); rs != nil {
+			root = rs.Root
+		}
+		return encoder.Encode(NewBaseline(s.Leaks(), root))
+	})
+}
@@ -0,0 +1,104 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+)
+
+func TestBaseline(t *testing.T) {
+	repo, _ := newFixtureRepo(t)
+	newScanner := func(baseline *Baseline) *GitScanner {
+		return &GitScanner{
+			Repo: repo,
+			RuleSet: &model.RuleSet{
+				IndepParsers: []model.IndepParserRule{{
+					Definition: "AKIA[0-9A-Z]{16}",
+					Compiled:   regexp.MustCompile("AKIA[0-9A-Z]{16}"),
+				}},
+			},
+			Baseline: baseline,
+			Output:   nopReport{},
+		}
+	}
+
+	g := newScanner(nil)
+	if _, err := g.Scan(context.Background(), ScanOptions{Concurrent: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Result) == 0 {
+		t.Fatal("expected leaks in the fixture repository")
+	}
+	b := NewBaseline(g.Result, "")
+	if len(b.Entries) != len(g.Result) {
+		t.Fatalf("expected %d entries, got %d", len(g.Result), len(b.Entries))
+	}
+
+	// Drop the first entry, scope the second one to another commit
+	// and add an entry which is not in the repository
+	entries := append([]BaselineEntry{}, b.Entries[1:]...)
+	entries[0].Commit = "0000000000000000000000000000000000000000"
+	stale := BaselineEntry{Rule: entries[0].Rule, File: "removed.txt", Hash: entries[0].Hash}
+	entries = append(entries, stale)
+	b = &Baseline{Version: BaselineVersion, Entries: entries}
+	b.init()
+
+	g = newScanner(b)
+	if _, err := g.Scan(context.Background(), ScanOptions{Concurrent: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Result) != 2 {
+		t.Errorf("expected 2 leaks to be reported, got %d", len(g.Result))
+	}
+	if b.Suppressed() != len(entries)-2 {
+		t.Errorf("expected %d suppressed leaks, got %d", len(entries)-2, b.Suppressed())
+	}
+	found := b.Stale()
+	if len(found) != 2 || found[0] != entries[0] || found[1] != stale {
+		t.Errorf("expected stale entries %v, got %v", []BaselineEntry{entries[0], stale}, found)
+	}
+}
+
+func TestFsBaselineRoot(t *testing.T) {
+	// The same tree checked out in two directories
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(dir, "config"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "config", "app.yaml"), []byte("key = AKIA0000000000000000\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := newTestFsScanner(dirs[0])
+	if _, err := f.Scan(context.Background(), ScanOptions{Concurrent: 1}); err != nil {
+		t.Fatal(err)
+	}
+	outfile := filepath.Join(t.TempDir(), "baseline.json")
+	if err := (BaselineReport{Outfile: outfile}).Write(f); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBaseline(outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Entries) != 1 || b.Entries[0].File != "config/app.yaml" {
+		t.Fatalf("expected the file to be relative to the root, got %+v", b.Entries)
+	}
+
+	f = newTestFsScanner(dirs[1])
+	f.Baseline = b
+	leaks, err := f.Scan(context.Background(), ScanOptions{Concurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaks) != 0 || b.Suppressed() != 1 {
+		t.Errorf("expected the leak to be suppressed in another checkout, got %d leaks", len(leaks))
+	}
+}
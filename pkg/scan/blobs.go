@@ -0,0 +1,64 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/ichbinfrog/excavator/pkg/model"
+	"github.com/rs/zerolog/log"
+	"github.com/schollz/progressbar/v3"
+)
+
+// scanBlobs indexes the blobs introduced by the walked commits and applies
+// the rules to each of them once, the leaks of the lines a blob adds to its
+// parent blob are reported at every path the blob has been introduced at
+// with the earliest commit introducing it there.
+// A blob found at several paths is parsed once per path since the
+// context parsers and the allow rules depend on the path.
+func (g *GitScanner) scanBlobs(ctx context.Context, workers int) ([]model.Leak, error) {
+	index, err := g.Repo.IndexBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().
+		Msg(fmt.Sprintf("Processing %d blobs with %d workers", len(index.Blobs), workers))
+
+	// progress bar initialisation
+	if g.Debug {
+		g.ProgressBar = progressbar.Default(int64(len(index.Blobs)), " scanning blobs")
+	}
+
+	jobs := enqueue(ctx, len(index.Blobs), func(idx int) job {
+		hash := index.Blobs[idx]
+		return job{idx: idx, run: func(leakChan chan model.Leak) {
+			g.scanBlobJob(hash, index.Origins[hash], leakChan)
+		}}
+	})
+	leaks := runPool(ctx, workers, jobs, streamOf(g.Output), g.Baseline)
+	return leaks, ctx.Err()
+}
+
+// scanBlobJob scans the blob at each of its origins, errors are
+// logged so that the other blobs are still scanned
+func (g *GitScanner) scanBlobJob(hash plumbing.Hash, origins []model.BlobOrigin, leakChan chan model.Leak) {
+	for _, origin := range origins {
+		key := "blob:" + hash.String() + ":" + origin.Commit.String() + ":" + origin.Path
+		if err := g.scanCached(key, origin.Commit, func(found chan model.Leak) error {
+			commit, err := g.Repo.Storer.CommitObject(origin.Commit)
+			if err != nil {
+				return err
+			}
+			return g.RuleSet.ParseBlob(hash, origin, commit, g.Repo, found)
+		}, leakChan); err != nil {
+			log.Error().
+				Str("blob", hash.String()).
+				Str("path", origin.Path).
+				Err(err).
+				Msg("Unable to scan blob")
+		}
+	}
+	if g.Debug {
+		g.ProgressBar.Add(1)
+	}
+}
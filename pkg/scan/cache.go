@@ -0,0 +1,349 @@
+package scan
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+	"github.com/rs/zerolog/log"
+)
+
+// CacheVersion is the version of the format of the cache files,
+// it is incremented whenever the findings of a same rule set may change
+const CacheVersion = "3"
+
+// DefaultCacheSize is the maximum size in bytes of the cache of a target
+const DefaultCacheSize = 256 << 20
+
+// cacheMeta is the name of the file describing the cache of a target
+const cacheMeta = "cache.json"
+
+// Cache stores the findings of the objects (commits, files) which have
+// already been scanned with the same rules so that subsequent scans of a
+// target only process new objects. The cache of a target is a directory
+// holding a json file per object which is emptied when the checksum of
+// the rules changes, entries are read and written as the objects are
+// scanned so that the cache is never entirely held in memory.
+//
+// Once the scan is over the least recently used entries are removed until
+// the cache fits in MaxSize, entries which were not used by a complete scan
+// are removed beforehand (files which have been modified since).
+//
+// The cache contains the snippets of the findings, it is only readable
+// by the user and kept out of the scanned trees (see DefaultCacheDir).
+//
+// Findings suppressed by pragmas or the allow section are not stored,
+// the suppression counts of the reports only cover the scanned objects.
+type Cache struct {
+	Version string `json:"version"`
+	// Checksum of the rules the findings were found with
+	Checksum string `json:"checksum"`
+
+	// Directory of the cache of the target
+	Path string `json:"-"`
+	// Maximum size in bytes of the entries (DefaultCacheSize if 0)
+	MaxSize int64          `json:"-"`
+	RuleSet *model.RuleSet `json:"-"`
+
+	// Entries used by the scan are touched with this time
+	// (truncated to the second as the precision of the fs may be)
+	opened time.Time
+
+	mu           sync.Mutex
+	hits, misses int
+}
+
+// CacheEntry is the findings of a scanned object
+type CacheEntry struct {
+	Leaks []cachedLeak `json:"leaks"`
+}
+
+// cachedLeak is a leak with the reference of its rule (see model.RuleSet.RuleRef)
+type cachedLeak struct {
+	Rule string          `json:"rule"`
+	Git  *model.GitLeak  `json:"git,omitempty"`
+	File *model.FileLeak `json:"file,omitempty"`
+}
+
+// DefaultCacheDir returns the directory of the cache files
+// within the cache directory of the user (see os.UserCacheDir)
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "excavator"), nil
+}
+
+// OpenCache opens the cache of the target scanned by the type of scanner
+// in the given directory, the cache is created if it does not exist and
+// emptied if it was created with other rules
+func OpenCache(dir, scanner, target string, rs *model.RuleSet) (*Cache, error) {
+	sum := sha1.Sum([]byte(target))
+	c := &Cache{
+		Version:  CacheVersion,
+		Checksum: rs.Checksum,
+		Path:     filepath.Join(dir, scanner+"-"+hex.EncodeToString(sum[:])[:16]),
+		RuleSet:  rs,
+		opened:   time.Now().Truncate(time.Second),
+	}
+
+	stored, err := readCacheMeta(c.Path)
+	switch {
+	case err == nil && stored.Version == c.Version && stored.Checksum == c.Checksum:
+		return c, nil
+	case err != nil && !os.IsNotExist(err):
+		log.Debug().
+			Str("path", c.Path).
+			Err(err).
+			Msg("Discarding unreadable cache")
+	}
+	if err := os.RemoveAll(c.Path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(c.Path, 0700); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %w", c.Path, err)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal cache: %w", err)
+	}
+	if err := writeFile(filepath.Join(c.Path, cacheMeta), data); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readCacheMeta reads the description of the cache of a target
+func readCacheMeta(path string) (*Cache, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, cacheMeta))
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("unable to parse cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// writeFile writes the file only readable by the user, the previous
+// file is only replaced once the new one has been completely written
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// entryPath returns the path of the file of the object
+func (c *Cache) entryPath(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(c.Path, hex.EncodeToString(sum[:])+".json")
+}
+
+// Get returns the findings of the object if it has already been scanned,
+// it is safe for concurrent use and nil caches never contain any object
+func (c *Cache) Get(key string) ([]model.Leak, bool) {
+	if c == nil {
+		return nil, false
+	}
+	path := c.entryPath(key)
+	var entry CacheEntry
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &entry)
+	}
+	c.mu.Lock()
+	if err == nil {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return nil, false
+	}
+	// The entry is marked as used by this scan
+	os.Chtimes(path, c.opened, c.opened)
+
+	leaks := []model.Leak{}
+	for _, cached := range entry.Leaks {
+		indep, ctx, entropy, ok := c.RuleSet.ResolveRule(cached.Rule)
+		if !ok {
+			continue
+		}
+		switch {
+		case cached.Git != nil:
+			leak := *cached.Git
+			leak.IndepParserRule, leak.CtxParserRule, leak.EntropyRule = indep, ctx, entropy
+			leaks = append(leaks, leak)
+		case cached.File != nil:
+			leak := *cached.File
+			leak.IndepParserRule, leak.CtxParserRule, leak.EntropyRule = indep, ctx, entropy
+			leaks = append(leaks, leak)
+		}
+	}
+	return leaks, true
+}
+
+// Put records the findings of the scanned object, failures to write
+// the entry are logged as the object is scanned again next time
+func (c *Cache) Put(key string, leaks []model.Leak) {
+	if c == nil {
+		return
+	}
+	entry := CacheEntry{Leaks: []cachedLeak{}}
+	for _, leak := range leaks {
+		switch disc := leak.(type) {
+		case model.GitLeak:
+			entry.Leaks = append(entry.Leaks, cachedLeak{
+				Rule: c.RuleSet.RuleRef(disc.IndepParserRule, disc.CtxParserRule, disc.EntropyRule),
+				Git:  &disc,
+			})
+		case model.FileLeak:
+			entry.Leaks = append(entry.Leaks, cachedLeak{
+				Rule: c.RuleSet.RuleRef(disc.IndepParserRule, disc.CtxParserRule, disc.EntropyRule),
+				File: &disc,
+			})
+		}
+	}
+	path := c.entryPath(key)
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = writeFile(path, data)
+	}
+	if err == nil {
+		err = os.Chtimes(path, c.opened, c.opened)
+	}
+	if err != nil {
+		log.Debug().
+			Str("path", path).
+			Err(err).
+			Msg("Failed to write cache entry")
+	}
+}
+
+// Stats returns the amount of objects found and not found in the cache
+func (c *Cache) Stats() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Prune removes the entries which were not used by the scan if complete
+// (the scan went through every object of the target), then the least
+// recently used entries until the cache fits in MaxSize
+func (c *Cache) Prune(complete bool) error {
+	if c == nil {
+		return nil
+	}
+	files, err := ioutil.ReadDir(c.Path)
+	if err != nil {
+		return err
+	}
+	maxSize := c.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultCacheSize
+	}
+
+	entries := []os.FileInfo{}
+	var size int64
+	for _, file := range files {
+		if file.IsDir() || file.Name() == cacheMeta || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		if complete && file.ModTime().Before(c.opened) {
+			if err := os.Remove(filepath.Join(c.Path, file.Name())); err != nil {
+				return err
+			}
+			continue
+		}
+		entries = append(entries, file)
+		size += file.Size()
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+	for _, entry := range entries {
+		if size <= maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.Path, entry.Name())); err != nil {
+			return err
+		}
+		size -= entry.Size()
+	}
+	return nil
+}
+
+// saveCache prunes the cache once the scan is over, complete
+// is whether or not the scan went through every object
+func saveCache(c *Cache, complete bool) {
+	if c == nil {
+		return
+	}
+	hits, misses := c.Stats()
+	log.Info().
+		Int("cached", hits).
+		Int("scanned", misses).
+		Msg("Cache used")
+	if err := c.Prune(complete); err != nil {
+		log.Warn().
+			Str("path", c.Path).
+			Err(err).
+			Msg("Failed to prune cache")
+	}
+}
+
+// PruneCache removes the caches of the directory which were created
+// with other rules or by another version of the cache format,
+// or all of them if rs is nil. The removed paths are returned.
+func PruneCache(dir string, rs *model.RuleSet) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		if rs != nil && file.IsDir() {
+			if stored, err := readCacheMeta(path); err == nil && stored.Version == CacheVersion && stored.Checksum == rs.Checksum {
+				continue
+			}
+		}
+		if !file.IsDir() && !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		// Cache files of the previous versions are removed as well
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
@@ -0,0 +1,151 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	repo, _ := newFixtureRepo(t)
+	scan := func(checksum string) (*GitScanner, *Cache) {
+		rs := &model.RuleSet{
+			Checksum: checksum,
+			IndepParsers: []model.IndepParserRule{{
+				Definition: "AKIA[0-9A-Z]{16}",
+				Compiled:   regexp.MustCompile("AKIA[0-9A-Z]{16}"),
+			}},
+		}
+		c, err := OpenCache(dir, "git", "fixture", rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := &GitScanner{Repo: repo, RuleSet: rs, ScanCache: c, Output: nopReport{}}
+		if _, err := g.Scan(context.Background(), ScanOptions{Concurrent: 2}); err != nil {
+			t.Fatal(err)
+		}
+		return g, c
+	}
+	found := func(g *GitScanner) string {
+		res := []string{}
+		for _, leak := range g.Result {
+			disc := leak.(model.GitLeak)
+			if disc.IndepParserRule != &g.RuleSet.IndepParsers[0] {
+				t.Errorf("expected the rule of %+v to be resolved", disc)
+			}
+			res = append(res, disc.Commit+":"+disc.File+":"+disc.Match())
+		}
+		sort.Strings(res)
+		return strings.Join(res, " ")
+	}
+
+	first, c := scan("a")
+	if hits, misses := c.Stats(); hits != 0 || misses != 5 {
+		t.Errorf("expected 5 commits to be scanned, got %d cached and %d scanned", hits, misses)
+	}
+	if info, err := os.Stat(c.Path); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("expected the cache to only be readable by its owner, got %v (%v)", info, err)
+	}
+	for _, entry := range cacheEntries(t, c) {
+		if entry.Mode().Perm() != 0600 {
+			t.Errorf("expected the entry %s to only be readable by its owner, got %v", entry.Name(), entry.Mode())
+		}
+	}
+	second, c := scan("a")
+	if hits, misses := c.Stats(); hits != 5 || misses != 0 {
+		t.Errorf("expected 5 commits to be cached, got %d cached and %d scanned", hits, misses)
+	}
+	if found(first) != found(second) {
+		t.Errorf("expected cached leaks %s, got %s", found(first), found(second))
+	}
+	if _, c = scan("b"); len(cacheEntries(t, c)) != 5 {
+		t.Errorf("expected the cache to be reset when the rules change, got %d entries", len(cacheEntries(t, c)))
+	}
+	if hits, _ := c.Stats(); hits != 0 {
+		t.Errorf("expected no commit to be cached with other rules, got %d", hits)
+	}
+
+	removed, err := PruneCache(dir, &model.RuleSet{Checksum: "b"})
+	if err != nil || len(removed) != 0 {
+		t.Errorf("expected the current cache to be kept, removed %v (%v)", removed, err)
+	}
+	removed, err = PruneCache(dir, &model.RuleSet{Checksum: "c"})
+	if err != nil || len(removed) != 1 {
+		t.Errorf("expected the outdated cache to be removed, removed %v (%v)", removed, err)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	src, dir := newSkewedDir(t, 0, 4), t.TempDir()
+	scan := func() *Cache {
+		f := newTestFsScanner(src)
+		f.RuleSet.Checksum = "a"
+		c, err := OpenCache(dir, "fs", src, f.RuleSet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.ScanCache = c
+		if _, err := f.Scan(context.Background(), ScanOptions{Concurrent: 2}); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	backdate := func(c *Cache, entry os.FileInfo, age time.Duration) {
+		date := time.Now().Add(-age)
+		if err := os.Chtimes(filepath.Join(c.Path, entry.Name()), date, date); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := scan()
+	for _, entry := range cacheEntries(t, c) {
+		backdate(c, entry, time.Hour)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "b000.txt"), []byte("key = AKIA1111111111111111\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = scan()
+	if hits, misses := c.Stats(); hits != 3 || misses != 1 {
+		t.Errorf("expected 3 files to be cached, got %d cached and %d scanned", hits, misses)
+	}
+	entries := cacheEntries(t, c)
+	if len(entries) != 4 {
+		t.Errorf("expected the entry of the modified file to be pruned, got %d entries", len(entries))
+	}
+
+	// The least recently used entries are removed first
+	for i, entry := range entries[1:] {
+		backdate(c, entry, time.Duration(i+1)*time.Hour)
+	}
+	c.MaxSize = entries[0].Size()
+	if err := c.Prune(false); err != nil {
+		t.Fatal(err)
+	}
+	if left := cacheEntries(t, c); len(left) != 1 || left[0].Name() != entries[0].Name() {
+		t.Errorf("expected only the most recent entry to be kept, got %v", left)
+	}
+}
+
+// cacheEntries returns the files of the entries of the cache
+func cacheEntries(t *testing.T, c *Cache) []os.FileInfo {
+	files, err := ioutil.ReadDir(c.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []os.FileInfo{}
+	for _, file := range files {
+		if file.Name() != cacheMeta && filepath.Ext(file.Name()) == ".json" {
+			entries = append(entries, file)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,112 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+	"github.com/rs/zerolog/log"
+)
+
+// Stdin is the source path which reads the diff from the standard input
+const Stdin = "-"
+
+// DiffScanner stores configuration for scanning a unified diff
+// (diff -u, git diff, git format-patch) without its repository
+type DiffScanner struct {
+	// Path to the rule definition file
+	// If set to "" automatically used default resources.yaml embedded by packr
+	RulesPath string
+	// Path of the diff, Stdin reads it from the standard input
+	Source  string
+	RuleSet *model.RuleSet
+	Result  []model.Leak
+
+	// Known findings which are not reported (optional)
+	Baseline *Baseline
+	// Output writer interface
+	Output ReportInterface
+}
+
+// Type returns the string type of the scanner ("diff")
+func (d DiffScanner) Type() string {
+	return "diff"
+}
+
+// Leaks returns the potential leaks found by the scan
+func (d DiffScanner) Leaks() []model.Leak {
+	return d.Result
+}
+
+// Rules returns the rules applied during the scan
+func (d DiffScanner) Rules() *model.RuleSet {
+	return d.RuleSet
+}
+
+// NewDiffScanner creates a scanner of the diff at the given path
+func NewDiffScanner(source, rulespath string, output ReportInterface) (*DiffScanner, error) {
+	d := &DiffScanner{
+		Source:    source,
+		RulesPath: rulespath,
+		RuleSet:   &model.RuleSet{},
+		Output:    output,
+	}
+
+	if err := d.RuleSet.ParseConfig(d.RulesPath); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Scan parses the diff and applies the rules to the added lines
+// of each file
+func (d *DiffScanner) Scan(ctx context.Context, opts ScanOptions) (leaks []model.Leak, err error) {
+	startTime := time.Now()
+	stream := streamOf(d.Output)
+	if stream != nil {
+		stream.Start(d)
+		defer func() {
+			if err != nil {
+				stream.Abort(d, err)
+			}
+		}()
+	}
+
+	var input io.Reader = os.Stdin
+	if d.Source != Stdin {
+		f, err := os.Open(d.Source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open diff: %w", err)
+		}
+		defer f.Close()
+		input = f
+	}
+	files, err := model.ParseUnifiedDiff(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse diff %s: %w", d.Source, err)
+	}
+	log.Info().
+		Int("n_files", len(files)).
+		Msg("Processing patched files")
+
+	jobs := enqueue(ctx, len(files), func(idx int) job {
+		return job{idx: idx, run: func(leakChan chan model.Leak) {
+			d.RuleSet.ParsePatchFile(files[idx], leakChan)
+		}}
+	})
+	d.Result = runPool(ctx, opts.workers(), jobs, stream, d.Baseline)
+	if err := ctx.Err(); err != nil {
+		return d.Result, err
+	}
+
+	log.Info().
+		Str("duration", time.Since(startTime).String()).
+		Msg("Scan completed in")
+	log.Info().
+		Int("potential leaks", len(d.Result)).
+		Msg("Found")
+	return d.Result, writeReport(d.Output, d)
+}
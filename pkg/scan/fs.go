@@ -1,10 +1,13 @@
 package scan
 
 import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"sync"
 	"time"
 
 	"github.com/ichbinfrog/excavator/pkg/model"
@@ -22,6 +25,11 @@ type FsScanner struct {
 	ProgressBar *progressbar.ProgressBar
 	RuleSet     *model.RuleSet
 	Output      ReportInterface
+
+	// Known findings which are not reported (optional)
+	Baseline *Baseline
+	// Findings of the files scanned by previous runs (optional)
+	ScanCache *Cache
 }
 
 // Type returns the string type of the scanner ("fs")
@@ -29,8 +37,18 @@ func (f FsScanner) Type() string {
 	return "fs"
 }
 
+// Leaks returns the potential leaks found by the scan
+func (f FsScanner) Leaks() []model.Leak {
+	return f.Result
+}
+
+// Rules returns the rules applied during the scan
+func (f FsScanner) Rules() *model.RuleSet {
+	return f.RuleSet
+}
+
 // NewFsScanner creates a new FsScanner
-func NewFsScanner(root, rulespath string, output ReportInterface, debug bool) *FsScanner {
+func NewFsScanner(root, rulespath string, output ReportInterface, debug bool) (*FsScanner, error) {
 	f := &FsScanner{
 		Root:      root,
 		RulesPath: rulespath,
@@ -39,11 +57,13 @@ func NewFsScanner(root, rulespath string, output ReportInterface, debug bool) *F
 		Output:    output,
 	}
 
-	f.RuleSet.ParseConfig(f.RulesPath)
-	return f
+	if err := f.RuleSet.ParseConfig(f.RulesPath); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-func (f *FsScanner) getFiles() []string {
+func (f *FsScanner) getFiles() ([]string, error) {
 	log.Info().
 		Msg("Collecting all files")
 	var files []string
@@ -74,79 +94,117 @@ func (f *FsScanner) getFiles() []string {
 		files = append(files, path)
 		return nil
 	}); err != nil {
-		log.Fatal().
-			Str("path", f.Root).
-			Err(err).
-			Msg("Failed to recursively files under")
+		return nil, fmt.Errorf("failed to recursively list files under %s: %w", f.Root, err)
 	}
-	return files
+	return files, nil
 }
 
 // Scan iterates over each file recursively and use defined rules
 // to analyse for possible leaks
-func (f *FsScanner) Scan(concurrent int) {
+func (f *FsScanner) Scan(ctx context.Context, opts ScanOptions) (leaks []model.Leak, err error) {
 	startTime := time.Now()
-	files := f.getFiles()
-	chunkSize := len(files) / concurrent
-	if chunkSize == 0 {
-		log.Fatal().
-			Int("concurrent", concurrent).
-			Int("n_files", len(files)).
-			Msg("Amount of concurrent routines >> number of files")
+	f.RuleSet.Root = f.Root
+	if f.Baseline != nil {
+		f.Baseline.Root = f.Root
 	}
+	if stream := streamOf(f.Output); stream != nil {
+		stream.Start(f)
+		defer func() {
+			if err != nil {
+				stream.Abort(f, err)
+			}
+		}()
+	}
+	files, err := f.getFiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		log.Info().
+			Msg("No files to process")
+		return f.Result, writeReport(f.Output, f)
+	}
+	workers := opts.workers()
 	log.Info().
-		Msg(fmt.Sprintf("Processing %d files with chunk_size = %d", len(files), chunkSize))
+		Msg(fmt.Sprintf("Processing %d files with %d workers", len(files), workers))
 
 	// progress bar initialisation
 	if f.Debug {
 		f.ProgressBar = progressbar.Default(int64(len(files)), " scanning files")
 	}
 
-	// Divide the file list into equal size chunks
-	// and for each chunk launch a go routine that analyses
-	// each file sequentially for rule breaks.
-	var wg sync.WaitGroup
-	res := make([][]model.Leak, concurrent+1)
-
-	for i := 0; i < concurrent; i++ {
-		start := i * chunkSize
-		end := (i + 1) * chunkSize
-		if end >= len(files) {
-			end = len(files)
-		}
-		leakChan := make(chan model.Leak)
-		doneChan := make(chan bool)
-		wg.Add(1)
-		go f.scanChunk(start, end, files, leakChan, doneChan)
-		go leakReader(leakChan, doneChan, &wg, res, i)
-	}
-	wg.Wait()
+	// Each worker pulls the next file of the queue as soon as
+	// it is done with the previous one and analyses it for rule breaks.
+	jobs := enqueue(ctx, len(files), func(idx int) job {
+		return job{idx: idx, run: func(leakChan chan model.Leak) {
+			f.scanCachedFile(files[idx], leakChan)
+			if f.Debug {
+				f.ProgressBar.Add(1)
+			}
+		}}
+	})
+	f.Result = append(f.Result, runPool(ctx, workers, jobs, streamOf(f.Output), f.Baseline)...)
 
-	for _, chunk := range res {
-		f.Result = append(f.Result, chunk...)
-	}
 	if f.Debug {
 		f.ProgressBar.Clear()
 	}
+	// The files scanned before the cancellation are still cached
+	saveCache(f.ScanCache, ctx.Err() == nil)
+	if err := ctx.Err(); err != nil {
+		return f.Result, err
+	}
 	log.Info().
 		Str("duration", time.Since(startTime).String()).
 		Msg("Scan completed in")
 	log.Info().
 		Int("potential leaks", len(f.Result)).
 		Msg("Found")
-	f.Output.Write(f)
+	return f.Result, writeReport(f.Output, f)
 }
 
-func (f FsScanner) scanChunk(j, e int, files []string, leakChan chan model.Leak, doneChan chan bool) {
-	log.Trace().
-		Int("start_file", j).
-		Int("end_file", e).
-		Msg("Routine launched")
-	for _, file := range files[j:e] {
+// scanCachedFile reuses the findings of the file if its content has already
+// been scanned with the same rules, it is scanned and cached otherwise
+func (f FsScanner) scanCachedFile(file string, leakChan chan model.Leak) {
+	if f.ScanCache == nil {
 		f.RuleSet.Parse(file, leakChan)
-		if f.Debug {
-			f.ProgressBar.Add(1)
+		return
+	}
+	hash, err := fileHash(file)
+	if err != nil {
+		f.RuleSet.Parse(file, leakChan)
+		return
+	}
+	key := file + ":" + hash
+	if leaks, ok := f.ScanCache.Get(key); ok {
+		for _, leak := range leaks {
+			leakChan <- leak
 		}
+		return
+	}
+
+	found := make(chan model.Leak)
+	go func() {
+		f.RuleSet.Parse(file, found)
+		close(found)
+	}()
+	leaks := []model.Leak{}
+	for leak := range found {
+		leaks = append(leaks, leak)
+		leakChan <- leak
+	}
+	f.ScanCache.Put(key, leaks)
+}
+
+// fileHash returns the SHA-1 of the content of the file
+func fileHash(path string) (string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, fd); err != nil {
+		return "", err
 	}
-	doneChan <- true
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
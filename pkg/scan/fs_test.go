@@ -1,22 +1,142 @@
 package scan
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
 )
 
 func TestFsClient(t *testing.T) {
-	f := NewFsScanner(".", "../../resources/rules.yaml", &HTMLReport{}, false)
-	f.Scan(5)
+	f, err := NewFsScanner(".", "../../resources/rules.yaml", &HTMLReport{Outfile: filepath.Join(t.TempDir(), "index.html")}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Scan(context.Background(), ScanOptions{Concurrent: 5}); err != nil {
+		t.Fatal(err)
+	}
 }
 func BenchmarkFSScan(b *testing.B) {
 	conccurrent := []int{50}
 	for _, i := range conccurrent {
 		b.Run(fmt.Sprintf("fsscan_%d", i), func(b *testing.B) {
 			b.StartTimer()
-			g := NewFsScanner("../..", "../../resources/rules.yaml", &HTMLReport{}, false)
-			g.Scan(i)
+			g, err := NewFsScanner("../..", "../../resources/rules.yaml", &HTMLReport{Outfile: filepath.Join(b.TempDir(), "index.html")}, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := g.Scan(context.Background(), ScanOptions{Concurrent: i}); err != nil {
+				b.Fatal(err)
+			}
 			b.ResetTimer()
 		})
 	}
 }
+
+// newSkewedDir writes a few large files followed by many small ones,
+// all of them containing a leak on their last line
+func newSkewedDir(tb testing.TB, large, small int) string {
+	dir := tb.TempDir()
+	write := func(name string, lines int) {
+		content := strings.Repeat("name = value\n", lines) + "key = AKIA0000000000000000\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	for i := 0; i < large; i++ {
+		write(fmt.Sprintf("a%03d.txt", i), 20000)
+	}
+	for i := 0; i < small; i++ {
+		write(fmt.Sprintf("b%03d.txt", i), 10)
+	}
+	return dir
+}
+
+func newTestFsScanner(root string) *FsScanner {
+	return &FsScanner{
+		Root: root,
+		RuleSet: &model.RuleSet{
+			IndepParsers: []model.IndepParserRule{{
+				Definition: "AKIA[0-9A-Z]{16}",
+				Compiled:   regexp.MustCompile("AKIA[0-9A-Z]{16}"),
+			}},
+		},
+	}
+}
+
+func TestFsScanWorkers(t *testing.T) {
+	dir := newSkewedDir(t, 1, 6)
+	// More workers than files and divisions with a remainder
+	for _, concurrent := range []int{0, 1, 2, 4, 20} {
+		t.Run(fmt.Sprintf("workers_%d", concurrent), func(t *testing.T) {
+			leaks, err := newTestFsScanner(dir).Scan(context.Background(), ScanOptions{Concurrent: concurrent})
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := []string{}
+			for _, leak := range leaks {
+				files = append(files, filepath.Base(leak.(model.FileLeak).File))
+			}
+			expected := "a000.txt b000.txt b001.txt b002.txt b003.txt b004.txt b005.txt"
+			if strings.Join(files, " ") != expected {
+				t.Errorf("expected leaks of %s in order, got %v", expected, files)
+			}
+		})
+	}
+}
+
+// BenchmarkFsScanSkewed scans a directory which large files are
+// all at the beginning of the walk (they used to end up in the same chunk)
+func BenchmarkFsScanSkewed(b *testing.B) {
+	dir := newSkewedDir(b, 4, 200)
+	for _, concurrent := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("workers_%d", concurrent), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := newTestFsScanner(dir).Scan(context.Background(), ScanOptions{Concurrent: concurrent}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFsScanAllowRoot(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"config/app.yaml", "config/nested/app.yaml", "other/config/app.yaml"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("key = AKIA0000000000000000\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := newTestFsScanner(dir)
+	// Anchored globs are matched from the root of the scan
+	f.RuleSet.Allow = []model.AllowRule{{Paths: []string{"/config/*.yaml"}}}
+	if err := f.RuleSet.Allow[0].Init(); err != nil {
+		t.Fatal(err)
+	}
+	leaks, err := f.Scan(context.Background(), ScanOptions{Concurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []string{}
+	for _, leak := range leaks {
+		files = append(files, model.RelativePath(dir, leak.(model.FileLeak).File))
+	}
+	sort.Strings(files)
+	if strings.Join(files, " ") != "config/nested/app.yaml other/config/app.yaml" {
+		t.Errorf("expected only config/app.yaml to be allowed, got %v", files)
+	}
+	if f.RuleSet.Suppressed.AllowList != 1 {
+		t.Errorf("expected a single allowed finding, got %d", f.RuleSet.Suppressed.AllowList)
+	}
+}
@@ -1,16 +1,40 @@
 package scan
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/ichbinfrog/excavator/pkg/model"
 	"github.com/rs/zerolog/log"
 	"github.com/schollz/progressbar/v3"
 )
 
+// Policies defining how merge commits are diffed against their parents
+const (
+	// FirstParent only diffs merge commits against their first parent
+	// (changes brought by the merged branch are scanned in its own commits)
+	FirstParent = "first-parent"
+	// AllParents diffs merge commits against each of their parents
+	AllParents = "all-parents"
+	// Combined only reports lines of merge commits that differ from all
+	// of their parents (conflict resolutions, evil merges)
+	Combined = "combined"
+)
+
+// Modes defining how the history of the repository is scanned
+const (
+	// CommitsMode applies the rules to the lines added by each commit
+	CommitsMode = "commits"
+	// BlobsMode applies the rules to the whole content of each unique blob
+	// once and attributes the leaks to the earliest commits introducing it
+	BlobsMode = "blobs"
+)
+
 // GitScanner stores configuration for scanning a git repository
 type GitScanner struct {
 	// Where to store local copy of the repo
@@ -22,6 +46,16 @@ type GitScanner struct {
 	RuleSet   *model.RuleSet
 	Result    []model.Leak
 
+	// How the history is scanned (defaults to CommitsMode)
+	Mode string
+	// Policy used for diffing merge commits (defaults to FirstParent)
+	// not used in BlobsMode
+	MergePolicy string
+	// Known findings which are not reported (optional)
+	Baseline *Baseline
+	// Findings of the commits scanned by previous runs (optional)
+	ScanCache *Cache
+
 	// Whether or not to display progressbar (mainly for testing)
 	Debug       bool
 	ProgressBar *progressbar.ProgressBar
@@ -34,8 +68,19 @@ func (g GitScanner) Type() string {
 	return "git"
 }
 
-// NewGitScanner creates a new git Scanner
-func NewGitScanner(source, cache, rulespath string, output ReportInterface, debug bool) *GitScanner {
+// Leaks returns the potential leaks found by the scan
+func (g GitScanner) Leaks() []model.Leak {
+	return g.Result
+}
+
+// Rules returns the rules applied during the scan
+func (g GitScanner) Rules() *model.RuleSet {
+	return g.RuleSet
+}
+
+// NewGitScanner creates a new git Scanner, remote repositories
+// are cloned in the cache directory (the clone honours the context)
+func NewGitScanner(ctx context.Context, source, cache, rulespath string, output ReportInterface, debug bool) (*GitScanner, error) {
 	g := &GitScanner{
 		Repo:      &model.Repo{},
 		Cache:     cache,
@@ -45,56 +90,46 @@ func NewGitScanner(source, cache, rulespath string, output ReportInterface, debu
 		Output:    output,
 	}
 
-	g.RuleSet.ParseConfig(g.RulesPath)
-	g.Repo.Init(source, g.Cache)
-	return g
+	if err := g.RuleSet.ParseConfig(g.RulesPath); err != nil {
+		return nil, err
+	}
+	if err := g.Repo.Init(ctx, source, g.Cache); err != nil {
+		return nil, err
+	}
+	return g, nil
 }
 
-// Scan iterates over each commits and use defined rules
-// to analyse for possible leaks
-func (g *GitScanner) Scan(concurrent int) {
+// Scan iterates over each commits (or each blob in BlobsMode)
+// and use defined rules to analyse for possible leaks
+func (g *GitScanner) Scan(ctx context.Context, opts ScanOptions) (leaks []model.Leak, err error) {
 	startTime := time.Now()
-	commits := g.Repo.FetchCommits()
-	chunkSize := len(commits) / concurrent
-	if chunkSize == 0 {
-		log.Fatal().
-			Int("concurrent", concurrent).
-			Int("n_commits", len(commits)).
-			Msg("Amount of concurrent routines >> number of commits")
+	if stream := streamOf(g.Output); stream != nil {
+		stream.Start(g)
+		defer func() {
+			if err != nil {
+				stream.Abort(g, err)
+			}
+		}()
 	}
-	log.Info().
-		Msg(fmt.Sprintf("Processing %d commits with chunk_size = %d", len(commits), chunkSize))
 
-	// progress bar initialisation
-	if g.Debug {
-		g.ProgressBar = progressbar.Default(int64(len(commits)), " scanning commits")
+	switch g.Mode {
+	case BlobsMode:
+		leaks, err = g.scanBlobs(ctx, opts.workers())
+	default:
+		leaks, err = g.scanCommits(ctx, opts.workers())
 	}
+	g.Result = append(g.Result, leaks...)
 
-	// Divide the commit structure into equal size chunks
-	// and for each chunk launch a go routine that analyses
-	// each commit sequentially for rule breaks.
-	var wg sync.WaitGroup
-	res := make([][]model.Leak, concurrent+1)
-
-	for i := 0; i < concurrent; i++ {
-		start := i * chunkSize
-		end := (i + 1) * chunkSize
-		if end >= len(commits) {
-			end = len(commits)
-		}
-		leakChan := make(chan model.Leak)
-		doneChan := make(chan bool)
-		wg.Add(1)
-		go g.scanChunk(start, end, commits, leakChan, doneChan)
-		go leakReader(leakChan, doneChan, &wg, res, i)
+	if g.Debug && g.ProgressBar != nil {
+		g.ProgressBar.Clear()
 	}
-	wg.Wait()
-	for _, chunk := range res {
-		g.Result = append(g.Result, chunk...)
+	// The objects scanned before the cancellation are still cached
+	saveCache(g.ScanCache, false)
+	if err := ctx.Err(); err != nil {
+		return g.Result, err
 	}
-
-	if g.Debug {
-		g.ProgressBar.Clear()
+	if err != nil {
+		return g.Result, err
 	}
 	log.Info().
 		Str("duration", time.Since(startTime).String()).
@@ -102,46 +137,273 @@ func (g *GitScanner) Scan(concurrent int) {
 	log.Info().
 		Int("potential leaks", len(g.Result)).
 		Msg("Found")
-	g.Output.Write(g)
+	return g.Result, writeReport(g.Output, g)
+}
+
+// scanCommits applies the rules to the lines added by each commit
+func (g *GitScanner) scanCommits(ctx context.Context, workers int) ([]model.Leak, error) {
+	log.Info().
+		Msg(fmt.Sprintf("Processing commits with %d workers", workers))
+
+	// progress bar initialisation, the commits are only known once they
+	// are walked so the rate of the scan and the date reached are shown
+	var progressMu sync.Mutex
+	progress := func(commit *object.Commit) {
+		if !g.Debug {
+			return
+		}
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		g.ProgressBar.Describe(fmt.Sprintf(" scanning commits (%s)", commit.Committer.When.Format("2006-01-02")))
+		g.ProgressBar.Add(1)
+	}
+	if g.Debug {
+		g.ProgressBar = progressbar.NewOptions64(-1,
+			progressbar.OptionSetDescription(" scanning commits"),
+			progressbar.OptionSetWriter(os.Stderr),
+			progressbar.OptionThrottle(65*time.Millisecond),
+			progressbar.OptionShowCount(),
+			progressbar.OptionShowIts(),
+			progressbar.OptionSetItsString("commits"),
+			progressbar.OptionSpinnerType(14),
+			progressbar.OptionFullWidth(),
+		)
+	}
+
+	// The commits are sent to the workers as they are walked, the queue
+	// being bounded the history is never entirely held in memory.
+	// Each worker pulls the next commit of the queue as soon as
+	// it is done with the previous one and analyses it for rule breaks.
+	jobs := make(chan job, workers)
+	var walkErr error
+	go func() {
+		defer close(jobs)
+		idx := 0
+		walkErr = g.Repo.WalkCommits(func(commit *object.Commit) error {
+			j := job{idx: idx, run: func(leakChan chan model.Leak) {
+				g.scanJob(commit, leakChan)
+				progress(commit)
+			}}
+			idx++
+			select {
+			case jobs <- j:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	leaks := runPool(ctx, workers, jobs, streamOf(g.Output), g.Baseline)
+	if ctx.Err() != nil {
+		return leaks, ctx.Err()
+	}
+	// The queue is closed once the walk is over
+	return leaks, walkErr
+}
+
+// scanJob scans the commit, errors are logged so that
+// the other commits are still scanned
+func (g *GitScanner) scanJob(commit *object.Commit, leakChan chan model.Leak) {
+	policy := g.MergePolicy
+	if policy == "" {
+		policy = FirstParent
+	}
+	key := commit.Hash.String() + ":" + policy
+	if err := g.scanCached(key, commit.Hash, func(found chan model.Leak) error {
+		return g.scanCommit(commit, found)
+	}, leakChan); err != nil {
+		log.Error().
+			Str("commit", commit.Hash.String()).
+			Err(err).
+			Msg("Unable to diff commit against its parents")
+	}
+}
+
+// scanCached reuses the findings of the object (commit or blob) if it has
+// already been scanned with the same rules and options, it is scanned and
+// cached otherwise. The refs of the leaks are those of the given commit.
+func (g *GitScanner) scanCached(key string, commit plumbing.Hash, scan func(chan model.Leak) error, leakChan chan model.Leak) error {
+	if g.ScanCache == nil {
+		return scan(leakChan)
+	}
+	if leaks, ok := g.ScanCache.Get(key); ok {
+		for _, leak := range leaks {
+			disc := leak.(model.GitLeak)
+			// Refs may have changed since the object was cached
+			disc.Refs = g.Repo.Refs(commit)
+			disc.Repo = g.Repo
+			leakChan <- disc
+		}
+		return nil
+	}
+
+	found := make(chan model.Leak)
+	done := make(chan error, 1)
+	go func() {
+		done <- scan(found)
+		close(found)
+	}()
+	leaks := []model.Leak{}
+	for leak := range found {
+		leaks = append(leaks, leak)
+		leakChan <- leak
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+	g.ScanCache.Put(key, leaks)
+	return nil
 }
 
-func (g *GitScanner) scanChunk(j, e int, commits []*object.Commit, leakChan chan model.Leak, doneChan chan bool) {
-	log.Trace().
-		Int("start_commit", j).
-		Int("end_commit", e-1).
-		Msg("Routine launched")
-	for idx, commit := range commits[j : e-1] {
-		currentTree, err := commit.Tree()
+// scanCommit diffs the commit against its parent(s) according to the
+// merge policy and applies the rules to the lines it introduced.
+// The root commit is considered as the addition of its whole tree.
+func (g *GitScanner) scanCommit(commit *object.Commit, leakChan chan model.Leak) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	if commit.NumParents() == 0 {
+		changes, err := object.DiffTree(nil, tree)
 		if err != nil {
-			log.Error().
-				Int("commit_index", j+idx).
-				Err(err).
-				Msg("Unable to fetch tree from current commit")
-			continue
+			return err
 		}
-		nextTree, err := commits[j+idx+1].Tree()
+		return g.parseChanges(changes, commit, leakChan)
+	}
+
+	parents := []*object.Commit{}
+	if err := commit.Parents().ForEach(func(parent *object.Commit) error {
+		parents = append(parents, parent)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	policy := g.MergePolicy
+	if len(parents) == 1 {
+		policy = FirstParent
+	}
+
+	switch policy {
+	case AllParents:
+		for _, parent := range parents {
+			changes, err := diffParent(parent, tree)
+			if err != nil {
+				return err
+			}
+			if err := g.parseChanges(changes, commit, leakChan); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Combined:
+		return g.scanCombined(parents, tree, commit, leakChan)
+	default:
+		changes, err := diffParent(parents[0], tree)
 		if err != nil {
-			log.Error().
-				Int("commit_index", j+idx+1).
-				Err(err).
-				Msg("Unable to fetch tree from next commit")
-			continue
+			return err
 		}
-		changes, err := currentTree.Diff(nextTree)
+		return g.parseChanges(changes, commit, leakChan)
+	}
+}
+
+// scanCombined only reports the leaks of the merge commit which offending
+// line has been added relatively to every parent
+func (g *GitScanner) scanCombined(parents []*object.Commit, tree *object.Tree, commit *object.Commit, leakChan chan model.Leak) error {
+	changes, err := diffParent(parents[0], tree)
+	if err != nil {
+		return err
+	}
+
+	// Lines added relatively to each of the other parents
+	others := []map[string]map[int]bool{}
+	for _, parent := range parents[1:] {
+		otherChanges, err := diffParent(parent, tree)
 		if err != nil {
-			log.Error().
-				Int("first_tree", j+idx).
-				Int("next_tree", j+idx+1).
-				Err(err).Msg("Unable to generate a tree diff")
-			continue
+			return err
 		}
-		for _, ch := range changes {
-			patch, _ := ch.Patch()
-			g.RuleSet.ParsePatch(patch, commits[j+idx+1], g.Repo, leakChan)
+		added, err := addedLines(otherChanges)
+		if err != nil {
+			return err
 		}
-		if g.Debug {
-			g.ProgressBar.Add(1)
+		others = append(others, added)
+	}
+
+	filtered := make(chan model.Leak)
+	done := make(chan error, 1)
+	go func() {
+		done <- g.parseChanges(changes, commit, filtered)
+		close(filtered)
+	}()
+	for leak := range filtered {
+		disc := leak.(model.GitLeak)
+		combined := true
+		for _, added := range others {
+			if !added[disc.File][disc.Line] {
+				combined = false
+				break
+			}
+		}
+		if combined {
+			leakChan <- leak
+		}
+	}
+	return <-done
+}
+
+// renameDetection are the options of the diffs against the parents, renamed
+// files are diffed against their previous version so that moving a file
+// does not report its whole content again (limit of git's diff.renameLimit)
+var renameDetection = &object.DiffTreeOptions{
+	DetectRenames: true,
+	RenameScore:   60,
+	RenameLimit:   1000,
+}
+
+// diffParent returns the changes from the tree of the parent to the given tree
+func diffParent(parent *object.Commit, tree *object.Tree) (object.Changes, error) {
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return object.DiffTreeWithOptions(context.Background(), parentTree, tree, renameDetection)
+}
+
+// addedLines returns the set of line numbers added by the changes for each file
+func addedLines(changes object.Changes) (map[string]map[int]bool, error) {
+	res := map[string]map[int]bool{}
+	for _, ch := range changes {
+		patch, err := ch.Patch()
+		if err != nil {
+			return nil, err
+		}
+		for _, filePatch := range patch.FilePatches() {
+			_, to := filePatch.Files()
+			if to == nil {
+				continue
+			}
+			if _, ok := res[to.Path()]; !ok {
+				res[to.Path()] = map[int]bool{}
+			}
+			_, added := model.PatchLines(filePatch)
+			for idx := range added {
+				if added[idx] {
+					res[to.Path()][idx+1] = true
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// parseChanges applies the rules to the patch of each change
+func (g *GitScanner) parseChanges(changes object.Changes, commit *object.Commit, leakChan chan model.Leak) error {
+	for _, ch := range changes {
+		patch, err := ch.Patch()
+		if err != nil {
+			return err
 		}
+		g.RuleSet.ParsePatch(patch, commit, g.Repo, leakChan)
 	}
-	doneChan <- true
+	return nil
 }
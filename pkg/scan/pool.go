@@ -0,0 +1,109 @@
+package scan
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+)
+
+// job is a unit of work of a scan (a commit, a file) which
+// sends the leaks it finds to the channel
+type job struct {
+	// Position of the job in the queue, the leaks are
+	// sorted by position once the scan is over
+	idx int
+	run func(leakChan chan model.Leak)
+}
+
+// found is a leak tagged with the position of its job
+type found struct {
+	idx  int
+	leak model.Leak
+}
+
+// workers returns the amount of workers of the scan
+// (defaults to the number of CPUs)
+func (o ScanOptions) workers() int {
+	if o.Concurrent < 1 {
+		return runtime.NumCPU()
+	}
+	return o.Concurrent
+}
+
+// enqueue returns a queue of n jobs built by newJob which
+// is closed once all jobs are taken or the context is done
+func enqueue(ctx context.Context, n int, newJob func(idx int) job) <-chan job {
+	jobs := make(chan job)
+	go func() {
+		defer close(jobs)
+		for idx := 0; idx < n; idx++ {
+			select {
+			case jobs <- newJob(idx):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return jobs
+}
+
+// runPool starts the workers pulling the jobs of the shared queue
+// until it is closed, so that a slow job only holds up its own worker.
+// The leaks of all workers are collected by a single routine which
+// drops the known findings of the baseline and streams the others
+// to the output, they are returned in the order of the queue.
+func runPool(ctx context.Context, workers int, jobs <-chan job, stream StreamReport, baseline *Baseline) []model.Leak {
+	results := make(chan found)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				runJob(j, results)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	res := []found{}
+	for r := range results {
+		if baseline.Suppress(r.leak) {
+			continue
+		}
+		res = append(res, r)
+		if stream != nil {
+			stream.WriteLeak(r.leak)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].idx < res[j].idx
+	})
+
+	leaks := make([]model.Leak, len(res))
+	for idx, r := range res {
+		leaks[idx] = r.leak
+	}
+	return leaks
+}
+
+// runJob runs the job and tags its leaks with its position
+func runJob(j job, results chan<- found) {
+	leakChan := make(chan model.Leak)
+	go func() {
+		j.run(leakChan)
+		close(leakChan)
+	}()
+	for leak := range leakChan {
+		results <- found{j.idx, leak}
+	}
+}
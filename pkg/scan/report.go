@@ -1,19 +1,28 @@
 package scan
 
 import (
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"os"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Masterminds/sprig"
 	"github.com/gobuffalo/packr/v2"
+	"github.com/ichbinfrog/excavator/pkg/model"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
+// Stdout is the output path which writes the report to the standard output
+const Stdout = "-"
+
 // ReportInterface modules writer behaviour for different reports
 type ReportInterface interface {
-	Write(Scanner)
+	Write(Scanner) error
 }
 
 // HTMLReport implements the ReportInterface to write html reports
@@ -23,30 +32,128 @@ type HTMLReport struct {
 }
 
 // YamlReport implements the ReportInterface to write yaml reports
+// which follow the same schema as the json reports
 type YamlReport struct {
 	Outfile string
 }
 
-func createFile(path string) *os.File {
+// MultiReport implements the ReportInterface to write
+// the result of a scan in several reports
+type MultiReport []ReportInterface
+
+// NewReport returns the report writer of the comma separated formats
+// (html, yaml, json, jsonl, sarif, text).
+//
+// The output is the path of the report ("-" for the standard output),
+// when several formats are given it is used as the base name of the reports
+// to which the extension of each format is appended. Each report falls back
+// on its default path if the output is empty.
+func NewReport(formats, output string) (ReportInterface, error) {
+	names := strings.Split(formats, ",")
+	if len(names) > 1 && output == Stdout {
+		return nil, errors.New("several formats can not be written to the standard output")
+	}
+
+	reports := MultiReport{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		outfile := output
+		if len(names) > 1 && output != "" {
+			outfile = output + "." + name
+		}
+
+		switch name {
+		case "html":
+			reports = append(reports, &HTMLReport{Outfile: outfile})
+		case "yaml":
+			reports = append(reports, &YamlReport{Outfile: outfile})
+		case "json":
+			reports = append(reports, &JSONReport{Outfile: outfile})
+		case "jsonl":
+			reports = append(reports, &JSONLReport{Outfile: outfile})
+		case "sarif":
+			reports = append(reports, &SarifReport{Outfile: outfile})
+		case "text":
+			reports = append(reports, &TextReport{Outfile: outfile})
+		default:
+			return nil, fmt.Errorf("unknown output format %q, must be (html, yaml, json, jsonl, sarif, text)", name)
+		}
+	}
+	if len(reports) == 1 {
+		return reports[0], nil
+	}
+	return reports, nil
+}
+
+// nopCloser prevents the standard output from being closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// createOutput opens the path the report is written to
+// which is the standard output for "-"
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == Stdout {
+		return nopCloser{os.Stdout}, nil
+	}
 	f, err := os.Create(path)
 	if err != nil {
-		log.Fatal().
-			Str("output", path).
-			Err(err).
-			Msg("Unable to create file")
+		return nil, fmt.Errorf("unable to create %s: %w", path, err)
 	}
-	return f
+	return f, nil
 }
 
-func (h HTMLReport) Write(s Scanner) {
-	h.Outfile = "index.html"
-	f := createFile(h.Outfile)
-	defer f.Close()
+// writeOutput writes the data to the path and closes it
+func writeOutput(path string, write func(io.Writer) error) error {
+	f, err := createOutput(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	logOutput(path)
+	return nil
+}
+
+func logOutput(path string) {
+	if path == Stdout {
+		return
+	}
+	log.Info().
+		Str("path", path).
+		Msg("Output has been written to")
+}
+
+// runeColumn converts a byte index of the line into a column
+// counted in unicode code points starting from 1
+func runeColumn(line string, idx int) int {
+	if idx < 0 {
+		idx = 0
+	}
+	if idx > len(line) {
+		idx = len(line)
+	}
+	return utf8.RuneCountInString(line[:idx]) + 1
+}
+
+func (h HTMLReport) Write(s Scanner) error {
+	if h.Outfile == "" {
+		h.Outfile = "index.html"
+	}
 
 	box := packr.New(".", "./static")
 	report, err := box.FindString("report.gohtml")
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load static box")
+		return fmt.Errorf("failed to load static box: %w", err)
 	}
 
 	funcMap := sprig.FuncMap()
@@ -60,39 +167,71 @@ func (h HTMLReport) Write(s Scanner) {
 		return s[i:j]
 	}
 
-	h.Template = template.Must(template.New("report.gohtml").Funcs(
+	h.Template, err = template.New("report.gohtml").Funcs(
 		funcMap,
-	).Parse(report))
-	if err := h.Template.Execute(f, s); err != nil {
-		log.Fatal().
-			Err(err).
-			Msg("Failed to execute template")
+	).Parse(report)
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
 	}
+	return writeOutput(h.Outfile, func(w io.Writer) error {
+		return h.Template.Execute(w, s)
+	})
+}
 
-	log.Info().
-		Str("path", h.Outfile).
-		Msg("Output has been written to")
+func (y YamlReport) Write(s Scanner) error {
+	if y.Outfile == "" {
+		y.Outfile = time.Now().Format(time.RFC3339) + ".yaml"
+	}
+
+	data, err := yaml.Marshal(newDocument(s))
+	if err != nil {
+		return fmt.Errorf("unable to marshal report to yaml: %w", err)
+	}
+	return writeOutput(y.Outfile, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
 }
 
-func (y YamlReport) Write(s Scanner) {
-	y.Outfile = time.Now().Format(time.RFC3339) + ".yaml"
-	f := createFile(y.Outfile)
-	defer f.Close()
+// Start starts the reports which support streaming
+func (m MultiReport) Start(s Scanner) {
+	for _, report := range m {
+		if stream := streamOf(report); stream != nil {
+			stream.Start(s)
+		}
+	}
+}
 
-	data, err := yaml.Marshal(&s)
-	if err != nil || data == nil {
-		log.Fatal().
-			Err(err).
-			Msg("Unable to marshal structure to yaml")
+// WriteLeak forwards the leak to the reports which support streaming
+func (m MultiReport) WriteLeak(leak model.Leak) {
+	for _, report := range m {
+		if stream := streamOf(report); stream != nil {
+			stream.WriteLeak(leak)
+		}
 	}
+}
 
-	if _, err := f.Write(data); err != nil {
-		log.Fatal().
-			Str("output", y.Outfile).
-			Err(err).
-			Msg("Unable to write to file")
+// Abort forwards the error of the scan to the reports which support streaming
+func (m MultiReport) Abort(s Scanner, err error) {
+	for _, report := range m {
+		if stream := streamOf(report); stream != nil {
+			stream.Abort(s, err)
+		}
 	}
-	log.Info().
-		Str("path", y.Outfile).
-		Msg("Output has been written to")
+}
+
+// Write writes all the reports, a failing report does not prevent
+// the others from being written and the first error is returned
+func (m MultiReport) Write(s Scanner) error {
+	var res error
+	for _, report := range m {
+		if err := report.Write(s); err != nil {
+			if res == nil {
+				res = err
+				continue
+			}
+			log.Error().Err(err).Msg("Failed to write report")
+		}
+	}
+	return res
 }
@@ -0,0 +1,354 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+)
+
+// ReportVersion is the version of the schema of the json, jsonl and yaml reports
+// it is incremented whenever a field is removed or its meaning changes
+const ReportVersion = "1"
+
+// Document is the content of the json and yaml reports
+type Document struct {
+	Version  string    `json:"version" yaml:"version"`
+	Summary  Summary   `json:"summary" yaml:"summary"`
+	Findings []Finding `json:"findings" yaml:"findings"`
+}
+
+// Record is a line of the jsonl report, either a finding or the
+// summary which is written last ("error" with the summary of the
+// findings streamed so far if the scan failed)
+type Record struct {
+	Version string   `json:"version"`
+	Type    string   `json:"type"`
+	Finding *Finding `json:"finding,omitempty"`
+	Summary *Summary `json:"summary,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Summary gives an overview of the scan
+type Summary struct {
+	// Type of the scanner ("git", "fs", "staged" or "diff")
+	Scanner string `json:"scanner" yaml:"scanner"`
+	// Repository or directory scanned
+	Target string `json:"target" yaml:"target"`
+	// Amount of findings
+	Findings int `json:"findings" yaml:"findings"`
+	// Amount of findings per rule identifier
+	Rules map[string]int `json:"rules" yaml:"rules"`
+	// Amount of findings per confidence
+	Confidence  map[string]int `json:"confidence" yaml:"confidence"`
+	GeneratedAt time.Time      `json:"generated_at" yaml:"generated_at"`
+	// Findings suppressed by pragmas or the allow section of the rules
+	Suppressed model.Suppressions `json:"suppressed" yaml:"suppressed"`
+	// Findings suppressed by the baseline (only set with --baseline)
+	Baseline *BaselineSummary `json:"baseline,omitempty" yaml:"baseline,omitempty"`
+	// Rules the scan was run with
+	RuleSet RuleSetSummary `json:"ruleset" yaml:"ruleset"`
+}
+
+// RuleSetSummary identifies the exact rules a scan was run with
+type RuleSetSummary struct {
+	// Version of the schema declared by the rules definition file
+	APIVersion string `json:"api_version" yaml:"api_version"`
+	// SHA-256 of the rules definition file
+	Checksum string `json:"checksum" yaml:"checksum"`
+	// Path of the rules definition file, empty for the embedded rules
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// Finding is a potential leak
+type Finding struct {
+	// Identifier of the rule (e.g. "token/amazon-token", "parser/env", "entropy/hex")
+	RuleID      string `json:"rule_id" yaml:"rule_id"`
+	Description string `json:"description" yaml:"description"`
+	Category    string `json:"category" yaml:"category"`
+	// Path of the file (relative to the root of the repository for git scans)
+	File string `json:"file" yaml:"file"`
+	// Line of the leak starting from 1
+	Line int `json:"line" yaml:"line"`
+	// Line of the end of the leak (differs from Line for multi-line rules)
+	EndLine int `json:"end_line" yaml:"end_line"`
+	// Columns of the offending value within the line (the end column within
+	// EndLine) counted in unicode code points starting from 1 (the end column is exclusive)
+	StartColumn int `json:"start_column" yaml:"start_column"`
+	EndColumn   int `json:"end_column" yaml:"end_column"`
+	// "High", "Medium", "Low" or "Entropy"
+	Confidence string `json:"confidence" yaml:"confidence"`
+	// Shannon entropy of the offending value (entropy rules only)
+	Entropy float64 `json:"entropy,omitempty" yaml:"entropy,omitempty"`
+	// Flattened key of the offending value (structured parsers only)
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// Lines surrounding the leak, the first one being at SnippetLine
+	Snippet     []string `json:"snippet" yaml:"snippet"`
+	SnippetLine int      `json:"snippet_line" yaml:"snippet_line"`
+	// Hash of the rule, file and offending value which does not
+	// depend on the line nor the commit of the leak
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	// Commit which introduced the leak (git scans only)
+	Commit *CommitInfo `json:"commit,omitempty" yaml:"commit,omitempty"`
+}
+
+// CommitInfo is the metadata of the commit which introduced a leak
+type CommitInfo struct {
+	SHA            string    `json:"sha" yaml:"sha"`
+	Parents        []string  `json:"parents" yaml:"parents"`
+	Subject        string    `json:"subject" yaml:"subject"`
+	Author         string    `json:"author" yaml:"author"`
+	AuthorEmail    string    `json:"author_email" yaml:"author_email"`
+	Committer      string    `json:"committer" yaml:"committer"`
+	CommitterEmail string    `json:"committer_email" yaml:"committer_email"`
+	Date           time.Time `json:"date" yaml:"date"`
+	// Hash of the blob of the file after the commit
+	Blob string `json:"blob" yaml:"blob"`
+	// Refs containing the commit (only set with --all-refs)
+	Refs []string `json:"refs,omitempty" yaml:"refs,omitempty"`
+}
+
+// JSONReport implements the ReportInterface to write json reports
+type JSONReport struct {
+	Outfile string
+}
+
+// JSONLReport implements the StreamReport interface to write
+// each finding on its own line as soon as it is found
+type JSONLReport struct {
+	Outfile string
+
+	mu      sync.Mutex
+	output  io.WriteCloser
+	encoder *json.Encoder
+	closed  bool
+	// First error encountered while streaming
+	err error
+}
+
+// newFinding converts a leak into its report representation
+func newFinding(leak model.Leak) (Finding, bool) {
+	var f Finding
+	var rule model.RuleInfo
+	var snippet []string
+	var affected, last, start, end int
+
+	switch disc := leak.(type) {
+	case model.FileLeak:
+		rule = disc.Rule()
+		snippet, affected, last, start, end = disc.Snippet, disc.Affected, disc.EndAffected(), disc.StartIdx, disc.EndIdx
+		f = Finding{
+			File:        disc.File,
+			Line:        disc.Line,
+			Confidence:  disc.Confidence,
+			Entropy:     disc.Entropy,
+			Key:         disc.Key,
+			Fingerprint: disc.Fingerprint(),
+		}
+	case model.GitLeak:
+		rule = disc.Rule()
+		snippet, affected, last, start, end = disc.Snippet, disc.Affected, disc.EndAffected(), disc.StartIdx, disc.EndIdx
+		f = Finding{
+			File:        disc.File,
+			Line:        disc.Line,
+			Confidence:  disc.Confidence,
+			Entropy:     disc.Entropy,
+			Key:         disc.Key,
+			Fingerprint: disc.Fingerprint(),
+			Commit: &CommitInfo{
+				SHA:            disc.Commit,
+				Parents:        disc.Parents,
+				Subject:        disc.Subject,
+				Author:         disc.Author,
+				AuthorEmail:    disc.AuthorEmail,
+				Committer:      disc.Committer,
+				CommitterEmail: disc.CommitterEmail,
+				Date:           disc.When,
+				Blob:           disc.Blob,
+				Refs:           disc.Refs,
+			},
+		}
+	default:
+		return f, false
+	}
+
+	f.RuleID = rule.ID
+	f.Description = rule.Description
+	f.Category = rule.Category
+	f.Snippet = snippet
+	f.SnippetLine = f.Line - affected
+	f.EndLine = f.Line + last - affected
+	if affected >= 0 && affected <= last && last < len(snippet) {
+		f.StartColumn = runeColumn(snippet[affected], start)
+		f.EndColumn = runeColumn(snippet[last], end)
+	}
+	return f, true
+}
+
+// newSummary counts the findings of the scan
+func newSummary(s Scanner, findings []Finding) Summary {
+	summary := Summary{
+		Scanner:     s.Type(),
+		Findings:    len(findings),
+		Rules:       map[string]int{},
+		Confidence:  map[string]int{},
+		GeneratedAt: time.Now(),
+	}
+	if rs := s.Rules(); rs != nil {
+		summary.Suppressed = rs.Suppressed
+		summary.RuleSet = newRuleSetSummary(rs)
+	}
+	switch scanner := s.(type) {
+	case *GitScanner:
+		if scanner.Repo != nil {
+			summary.Target = scanner.Repo.Source
+		}
+		summary.Baseline = scanner.Baseline.Summary()
+	case *FsScanner:
+		summary.Target = scanner.Root
+		summary.Baseline = scanner.Baseline.Summary()
+	case *StagedScanner:
+		if scanner.Repo != nil {
+			summary.Target = scanner.Repo.Path
+		}
+		summary.Baseline = scanner.Baseline.Summary()
+	case *DiffScanner:
+		summary.Target = scanner.Source
+		summary.Baseline = scanner.Baseline.Summary()
+	}
+	for _, f := range findings {
+		summary.Rules[f.RuleID]++
+		summary.Confidence[f.Confidence]++
+	}
+	return summary
+}
+
+// newRuleSetSummary identifies the rules
+func newRuleSetSummary(rs *model.RuleSet) RuleSetSummary {
+	return RuleSetSummary{
+		APIVersion: rs.APIVersion,
+		Checksum:   rs.Checksum,
+		Path:       rs.Path,
+	}
+}
+
+// newDocument converts the result of the scan into a report
+func newDocument(s Scanner) Document {
+	findings := []Finding{}
+	for _, leak := range s.Leaks() {
+		if f, ok := newFinding(leak); ok {
+			findings = append(findings, f)
+		}
+	}
+	return Document{
+		Version:  ReportVersion,
+		Summary:  newSummary(s, findings),
+		Findings: findings,
+	}
+}
+
+func (j JSONReport) Write(s Scanner) error {
+	if j.Outfile == "" {
+		j.Outfile = "report.json"
+	}
+	return writeOutput(j.Outfile, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(newDocument(s))
+	})
+}
+
+// Start creates the output before the scan begins,
+// errors are returned once the report is written
+func (j *JSONLReport) Start(s Scanner) {
+	if j.Outfile == "" {
+		j.Outfile = "report.jsonl"
+	}
+	j.output, j.err = createOutput(j.Outfile)
+	if j.err == nil {
+		j.encoder = json.NewEncoder(j.output)
+	}
+}
+
+// WriteLeak writes the leak as a finding record
+func (j *JSONLReport) WriteLeak(leak model.Leak) {
+	f, ok := newFinding(leak)
+	if !ok {
+		return
+	}
+	j.encode(Record{Version: ReportVersion, Type: "finding", Finding: &f})
+}
+
+// Write ends the report with the summary record
+func (j *JSONLReport) Write(s Scanner) error {
+	if j.output == nil && j.err == nil {
+		// The leaks were not streamed, write them all at once
+		j.Start(s)
+		for _, leak := range s.Leaks() {
+			j.WriteLeak(leak)
+		}
+	}
+	if j.err != nil {
+		j.close()
+		return j.err
+	}
+
+	summary := j.summary(s)
+	j.encode(Record{Version: ReportVersion, Type: "summary", Summary: &summary})
+	if j.close(); j.err != nil {
+		return j.err
+	}
+	logOutput(j.Outfile)
+	return nil
+}
+
+// Abort ends the report with an error record and the summary of the
+// findings streamed so far, it does nothing once the report is closed
+func (j *JSONLReport) Abort(s Scanner, err error) {
+	if j.output == nil || j.closed {
+		return
+	}
+	summary := j.summary(s)
+	j.encode(Record{Version: ReportVersion, Type: "error", Summary: &summary, Error: err.Error()})
+	j.close()
+}
+
+// summary returns the summary of the findings of the scan
+func (j *JSONLReport) summary(s Scanner) Summary {
+	findings := []Finding{}
+	for _, leak := range s.Leaks() {
+		if f, ok := newFinding(leak); ok {
+			findings = append(findings, f)
+		}
+	}
+	return newSummary(s, findings)
+}
+
+// close closes the output, the error is kept if it is the first one
+func (j *JSONLReport) close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.output == nil || j.closed {
+		return
+	}
+	j.closed = true
+	if err := j.output.Close(); err != nil && j.err == nil {
+		j.err = fmt.Errorf("unable to write %s: %w", j.Outfile, err)
+	}
+}
+
+// encode writes the record, the first error is kept
+// and the following records are dropped
+func (j *JSONLReport) encode(record Record) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.err != nil {
+		return
+	}
+	if err := j.encoder.Encode(record); err != nil {
+		j.err = fmt.Errorf("unable to write %s: %w", j.Outfile, err)
+	}
+}
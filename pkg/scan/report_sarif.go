@@ -0,0 +1,324 @@
+package scan
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+)
+
+const (
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion = "2.1.0"
+	// Key of the partial fingerprint computed from the rule, file and value
+	sarifFingerprint = "excavator/v1"
+	// Base of the uris of the findings, the root of the scan
+	sarifSrcRoot = "%SRCROOT%"
+)
+
+// SarifReport implements the ReportInterface to write SARIF 2.1.0 logs
+// which can be uploaded to code scanning platforms
+type SarifReport struct {
+	Outfile string
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool               sarifTool                        `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds"`
+	ColumnKind         string                           `json:"columnKind"`
+	Results            []sarifResult                    `json:"results"`
+	Properties         sarifRunProps                    `json:"properties"`
+}
+
+type sarifRunProps struct {
+	// Rules the scan was run with
+	RuleSet *RuleSetSummary `json:"ruleset,omitempty"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+	Properties           sarifRuleProps     `json:"properties"`
+}
+
+type sarifRuleProps struct {
+	Category string   `json:"category,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string                 `json:"ruleId"`
+	RuleIndex           int                    `json:"ruleIndex"`
+	Level               string                 `json:"level,omitempty"`
+	Message             sarifMessage           `json:"message"`
+	Locations           []sarifLocation        `json:"locations"`
+	PartialFingerprints map[string]string      `json:"partialFingerprints"`
+	Properties          map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+	ContextRegion    *sarifRegion          `json:"contextRegion,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI         string        `json:"uri,omitempty"`
+	URIBaseID   string        `json:"uriBaseId,omitempty"`
+	Description *sarifMessage `json:"description,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int           `json:"startLine"`
+	EndLine     int           `json:"endLine,omitempty"`
+	StartColumn int           `json:"startColumn,omitempty"`
+	EndColumn   int           `json:"endColumn,omitempty"`
+	Snippet     *sarifMessage `json:"snippet,omitempty"`
+}
+
+func (r SarifReport) Write(s Scanner) error {
+	if r.Outfile == "" {
+		r.Outfile = "report.sarif"
+	}
+	return writeOutput(r.Outfile, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(newSarifLog(s))
+	})
+}
+
+// newSarifLog converts the rules and the leaks of the scan
+// into a SARIF log of a single run
+func newSarifLog(s Scanner) sarifLog {
+	driver := sarifDriver{
+		Name:           "excavator",
+		InformationURI: "https://github.com/ichbinfrog/excavator",
+		Rules:          []sarifRule{},
+	}
+	index := map[string]int{}
+	addRule := func(info model.RuleInfo, level string) int {
+		if idx, ok := index[info.ID]; ok {
+			return idx
+		}
+		rule := sarifRule{
+			ID:                   info.ID,
+			ShortDescription:     sarifMessage{Text: info.Description},
+			DefaultConfiguration: sarifConfiguration{Level: level},
+			Properties:           sarifRuleProps{Category: info.Category},
+		}
+		if info.Category != "" {
+			rule.Properties.Tags = []string{"security", info.Category}
+		}
+		index[info.ID] = len(driver.Rules)
+		driver.Rules = append(driver.Rules, rule)
+		return index[info.ID]
+	}
+
+	if rs := s.Rules(); rs != nil {
+		for idx := range rs.IndepParsers {
+			addRule(rs.IndepParsers[idx].Info(), weightLevel(rs.IndepParsers[idx].Weight))
+		}
+		for idx := range rs.CtxParsers {
+			addRule(rs.CtxParsers[idx].Info(), confidenceLevel(rs.CtxParsers[idx].Confidence, "error"))
+		}
+		for idx := range rs.EntropyRules {
+			addRule(rs.EntropyRules[idx].Info(), "note")
+		}
+	}
+
+	// Findings of fs scans are located relatively to the scanned directory
+	// and those of git scans relatively to the root of the repository
+	root := ""
+	if rs := s.Rules(); rs != nil {
+		root = rs.Root
+	}
+	results := []sarifResult{}
+	for _, leak := range s.Leaks() {
+		finding, ok := newFinding(leak)
+		if !ok {
+			continue
+		}
+		var indep *model.IndepParserRule
+		var ctx *model.CtxParserRule
+		switch disc := leak.(type) {
+		case model.FileLeak:
+			indep, ctx = disc.IndepParserRule, disc.CtxParserRule
+		case model.GitLeak:
+			indep, ctx = disc.IndepParserRule, disc.CtxParserRule
+		}
+		ruleIndex := addRule(model.RuleInfo{
+			ID:          finding.RuleID,
+			Description: finding.Description,
+			Category:    finding.Category,
+		}, ruleLevel(indep, ctx))
+		finding.File = model.RelativePath(root, finding.File)
+		res := newSarifResult(finding, ruleIndex)
+		// Context parsers grade each of their findings
+		// whereas other findings use the level of their rule
+		if ctx != nil {
+			res.Level = confidenceLevel(finding.Confidence, "error")
+		}
+		results = append(results, res)
+	}
+
+	props := sarifRunProps{}
+	if rs := s.Rules(); rs != nil {
+		summary := newRuleSetSummary(rs)
+		props.RuleSet = &summary
+	}
+	return sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []sarifRun{{
+			Tool:               sarifTool{Driver: driver},
+			OriginalURIBaseIDs: map[string]sarifArtifactLocation{sarifSrcRoot: sarifRoot(root)},
+			ColumnKind:         "unicodeCodePoints",
+			Results:            results,
+			Properties:         props,
+		}},
+	}
+}
+
+// newSarifResult converts the finding into a SARIF result of the given rule
+func newSarifResult(f Finding, ruleIndex int) sarifResult {
+	region := sarifRegion{
+		StartLine:   f.Line,
+		StartColumn: f.StartColumn,
+		EndColumn:   f.EndColumn,
+	}
+	if f.EndLine > f.Line {
+		region.EndLine = f.EndLine
+	}
+	if affected, last := f.Line-f.SnippetLine, f.EndLine-f.SnippetLine; affected >= 0 && affected <= last && last < len(f.Snippet) {
+		region.Snippet = &sarifMessage{Text: strings.Join(f.Snippet[affected:last+1], "\n")}
+	}
+
+	location := sarifPhysicalLocation{
+		ArtifactLocation: sarifLocationOf(f.File),
+		Region:           region,
+	}
+	if len(f.Snippet) > 1 {
+		location.ContextRegion = &sarifRegion{
+			StartLine: f.SnippetLine,
+			EndLine:   f.SnippetLine + len(f.Snippet) - 1,
+			Snippet:   &sarifMessage{Text: strings.Join(f.Snippet, "\n")},
+		}
+	}
+
+	properties := map[string]interface{}{}
+	if f.Confidence != "" {
+		properties["confidence"] = f.Confidence
+	}
+	if f.Key != "" {
+		properties["key"] = f.Key
+	}
+	if f.Commit != nil {
+		properties["commit"] = f.Commit.SHA
+		properties["blob"] = f.Commit.Blob
+		properties["subject"] = f.Commit.Subject
+		properties["author"] = f.Commit.Author
+		properties["authorEmail"] = f.Commit.AuthorEmail
+		properties["date"] = f.Commit.Date
+		if len(f.Commit.Refs) > 0 {
+			properties["refs"] = f.Commit.Refs
+		}
+	}
+
+	return sarifResult{
+		RuleID:              f.RuleID,
+		RuleIndex:           ruleIndex,
+		Message:             sarifMessage{Text: "Potential leak (" + f.Description + ")"},
+		Locations:           []sarifLocation{{PhysicalLocation: location}},
+		PartialFingerprints: map[string]string{sarifFingerprint: f.Fingerprint},
+		Properties:          properties,
+	}
+}
+
+// ruleLevel returns the default level of the rule of a leak
+func ruleLevel(indep *model.IndepParserRule, ctx *model.CtxParserRule) string {
+	switch {
+	case indep != nil:
+		return weightLevel(indep.Weight)
+	case ctx != nil:
+		return confidenceLevel(ctx.Confidence, "error")
+	}
+	return "note"
+}
+
+// weightLevel converts the weight of a regex rule into a SARIF level
+// rules without weight are considered as warnings
+func weightLevel(weight float32) string {
+	switch {
+	case weight == 0:
+		return "warning"
+	case weight >= 0.7:
+		return "error"
+	case weight >= 0.4:
+		return "warning"
+	}
+	return "note"
+}
+
+// confidenceLevel converts a confidence into a SARIF level
+func confidenceLevel(confidence, fallback string) string {
+	switch confidence {
+	case "High":
+		return "error"
+	case "Medium":
+		return "warning"
+	case "Low", "Entropy":
+		return "note"
+	}
+	return fallback
+}
+
+// sarifLocationOf returns the location of the file, relative
+// paths are resolved against the root of the scan
+func sarifLocationOf(file string) sarifArtifactLocation {
+	if filepath.IsAbs(file) {
+		return sarifArtifactLocation{URI: "file://" + filepath.ToSlash(file)}
+	}
+	return sarifArtifactLocation{URI: filepath.ToSlash(file), URIBaseID: sarifSrcRoot}
+}
+
+// sarifRoot returns the location of the root of the scan, the root of
+// git scans is left to the consumer (e.g. the checkout of the repository)
+func sarifRoot(root string) sarifArtifactLocation {
+	if root != "" {
+		if abs, err := filepath.Abs(root); err == nil {
+			return sarifArtifactLocation{URI: "file://" + strings.TrimSuffix(filepath.ToSlash(abs), "/") + "/"}
+		}
+	}
+	return sarifArtifactLocation{Description: &sarifMessage{Text: "root of the scanned repository"}}
+}
@@ -1,27 +1,59 @@
 package scan
 
 import (
-	"sync"
+	"context"
+	"fmt"
 
 	"github.com/ichbinfrog/excavator/pkg/model"
 )
 
+// ScanOptions configures the execution of a scan
+type ScanOptions struct {
+	// Amount of routines analysing the commits or files
+	// (defaults to the number of CPUs)
+	Concurrent int
+}
+
 // Scanner is an interface for scanning behaviours
 type Scanner interface {
-	Scan(concurrent int)
+	// Scan returns the potential leaks found, the leaks found so far
+	// are returned along with the error of the context if it is cancelled
+	Scan(ctx context.Context, opts ScanOptions) ([]model.Leak, error)
 	Type() string
+	// Leaks returns the potential leaks found by the scan
+	Leaks() []model.Leak
+	// Rules returns the rules applied during the scan
+	Rules() *model.RuleSet
+}
+
+// StreamReport is implemented by reports which write each leak
+// as soon as it is found rather than once the scan is over
+type StreamReport interface {
+	ReportInterface
+	// Start is called before the scan begins
+	Start(Scanner)
+	// WriteLeak is called concurrently for each leak found
+	WriteLeak(model.Leak)
+	// Abort is called instead of Write when the scan fails after Start
+	// (or after Write if it failed), the report is ended with the error
+	Abort(Scanner, error)
 }
 
-func leakReader(leaksChan <-chan model.Leak, doneChan <-chan bool, task *sync.WaitGroup, res [][]model.Leak, idx int) {
-	leaks := []model.Leak{}
-	for {
-		select {
-		case leak := <-leaksChan:
-			leaks = append(leaks, leak)
-		case <-doneChan:
-			res[idx] = leaks
-			task.Done()
-			break
-		}
+// streamOf returns the output if it supports streaming or nil
+func streamOf(output ReportInterface) StreamReport {
+	if stream, ok := output.(StreamReport); ok {
+		return stream
+	}
+	return nil
+}
+
+// writeReport writes the report of the scan if an output is configured
+func writeReport(output ReportInterface, s Scanner) error {
+	if output == nil {
+		return nil
+	}
+	if err := output.Write(s); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,102 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/ichbinfrog/excavator/pkg/model"
+	"github.com/rs/zerolog/log"
+)
+
+// StagedScanner stores configuration for scanning the changes
+// staged in the index of a local git repository (pre-commit hooks)
+type StagedScanner struct {
+	// Path to the rule definition file
+	// If set to "" automatically used default resources.yaml embedded by packr
+	RulesPath string
+	Repo      *model.Repo
+	RuleSet   *model.RuleSet
+	Result    []model.Leak
+
+	// Known findings which are not reported (optional)
+	Baseline *Baseline
+	// Output writer interface
+	Output ReportInterface
+}
+
+// Type returns the string type of the scanner ("staged")
+func (s StagedScanner) Type() string {
+	return "staged"
+}
+
+// Leaks returns the potential leaks found by the scan
+func (s StagedScanner) Leaks() []model.Leak {
+	return s.Result
+}
+
+// Rules returns the rules applied during the scan
+func (s StagedScanner) Rules() *model.RuleSet {
+	return s.RuleSet
+}
+
+// NewStagedScanner creates a scanner of the changes staged
+// in the local repository at the given path
+func NewStagedScanner(repoPath, rulespath string, output ReportInterface) (*StagedScanner, error) {
+	s := &StagedScanner{
+		Repo:      &model.Repo{Source: repoPath, Path: repoPath},
+		RulesPath: rulespath,
+		RuleSet:   &model.RuleSet{},
+		Output:    output,
+	}
+
+	if err := s.RuleSet.ParseConfig(s.RulesPath); err != nil {
+		return nil, err
+	}
+	// Hooks and users may run the scan from any directory of the worktree
+	storer, err := git.PlainOpenWithOptions(repoPath, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository %s: %w", repoPath, err)
+	}
+	s.Repo.Storer = storer
+	return s, nil
+}
+
+// Scan diffs the index against HEAD and applies the rules to the
+// staged lines of each file
+func (s *StagedScanner) Scan(ctx context.Context, opts ScanOptions) (leaks []model.Leak, err error) {
+	startTime := time.Now()
+	stream := streamOf(s.Output)
+	if stream != nil {
+		stream.Start(s)
+		defer func() {
+			if err != nil {
+				stream.Abort(s, err)
+			}
+		}()
+	}
+	files, err := s.Repo.StagedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff the index of %s against HEAD: %w", s.Repo.Path, err)
+	}
+	log.Debug().
+		Int("n_files", len(files)).
+		Msg("Processing staged files")
+
+	jobs := enqueue(ctx, len(files), func(idx int) job {
+		return job{idx: idx, run: func(leakChan chan model.Leak) {
+			s.RuleSet.ParseAdditions(files[idx].Path, files[idx].Lines, files[idx].Added, leakChan)
+		}}
+	})
+	s.Result = runPool(ctx, opts.workers(), jobs, stream, s.Baseline)
+	if err := ctx.Err(); err != nil {
+		return s.Result, err
+	}
+
+	log.Debug().
+		Str("duration", time.Since(startTime).String()).
+		Int("potential leaks", len(s.Result)).
+		Msg("Scan completed")
+	return s.Result, writeReport(s.Output, s)
+}
@@ -0,0 +1,45 @@
+package scan
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+)
+
+func TestStagedScanSubdirectory(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config", "app.conf"), []byte("aws_key = AKIA1234567890ABCDEF\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("config/app.conf"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The repository is found from a subdirectory of the worktree
+	s, err := NewStagedScanner(filepath.Join(dir, "config"), "../../resources/rules.yaml", nopReport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaks, err := s.Scan(context.Background(), ScanOptions{Concurrent: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaks) == 0 {
+		t.Error("expected the staged leak to be found")
+	}
+}
@@ -0,0 +1,62 @@
+package scan
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+)
+
+// Threshold decides which findings fail a scan, either by their
+// confidence or by their weight (only one of the fields is set)
+type Threshold struct {
+	// Minimum confidence ("Entropy", "Low", "Medium" or "High")
+	Confidence string
+	// Minimum weight between 0 and 1, rules which do not define a weight
+	// use the weight of the confidence of their findings
+	// (Entropy 0.1, Low 0.3, Medium 0.5, High 1)
+	Weight float32
+}
+
+// ParseThreshold parses a confidence (case insensitive) or a weight
+// an empty value returns the threshold exceeded by all findings
+func ParseThreshold(value string) (Threshold, error) {
+	if value == "" {
+		return Threshold{Confidence: model.Confidences[0]}, nil
+	}
+	if rank := model.ConfidenceRank(value); rank != -1 {
+		return Threshold{Confidence: model.Confidences[rank]}, nil
+	}
+	weight, err := strconv.ParseFloat(value, 32)
+	if err != nil || weight < 0 || weight > 1 {
+		return Threshold{}, fmt.Errorf("invalid threshold %q, must be a confidence (%s) or a weight between 0 and 1",
+			value, strings.ToLower(strings.Join(model.Confidences, ", ")))
+	}
+	return Threshold{Weight: float32(weight)}, nil
+}
+
+// Exceeded returns whether or not the leak is at or above the threshold
+func (t Threshold) Exceeded(leak model.Leak) bool {
+	var confidence string
+	var weight float32
+	switch disc := leak.(type) {
+	case model.FileLeak:
+		confidence, weight = disc.Confidence, disc.Weight()
+	case model.GitLeak:
+		confidence, weight = disc.Confidence, disc.Weight()
+	default:
+		return false
+	}
+	if t.Confidence != "" {
+		return model.ConfidenceRank(confidence) >= model.ConfidenceRank(t.Confidence)
+	}
+	return weight >= t.Weight
+}
+
+func (t Threshold) String() string {
+	if t.Confidence != "" {
+		return strings.ToLower(t.Confidence)
+	}
+	return strconv.FormatFloat(float64(t.Weight), 'f', -1, 32)
+}
@@ -0,0 +1,53 @@
+package scan
+
+import (
+	"testing"
+
+	"github.com/ichbinfrog/excavator/pkg/model"
+)
+
+func TestThreshold(t *testing.T) {
+	weighted := &model.IndepParserRule{Definition: "weighted", Weight: 0.8}
+	unweighted := &model.IndepParserRule{Definition: "unweighted"}
+	leaks := map[string]model.Leak{
+		"entropy": model.FileLeak{Confidence: "Entropy"},
+		"low":     model.FileLeak{Confidence: "Low", IndepParserRule: unweighted},
+		"medium":  model.GitLeak{Confidence: "Medium"},
+		"high":    model.FileLeak{Confidence: "High"},
+		"weight":  model.GitLeak{Confidence: "Low", IndepParserRule: weighted},
+	}
+
+	tests := []struct {
+		threshold string
+		exceeded  []string
+	}{
+		{"", []string{"entropy", "high", "low", "medium", "weight"}},
+		{"medium", []string{"high", "medium"}},
+		{"HIGH", []string{"high"}},
+		{"low", []string{"high", "low", "medium", "weight"}},
+		{"0.5", []string{"high", "medium", "weight"}},
+		{"0.9", []string{"high"}},
+		{"0", []string{"entropy", "high", "low", "medium", "weight"}},
+	}
+	for _, test := range tests {
+		threshold, err := ParseThreshold(test.threshold)
+		if err != nil {
+			t.Fatalf("%q: %v", test.threshold, err)
+		}
+		expected := map[string]bool{}
+		for _, name := range test.exceeded {
+			expected[name] = true
+		}
+		for name, leak := range leaks {
+			if threshold.Exceeded(leak) != expected[name] {
+				t.Errorf("%q: expected %s exceeded to be %v", test.threshold, name, expected[name])
+			}
+		}
+	}
+
+	for _, invalid := range []string{"critical", "1.5", "-0.1"} {
+		if _, err := ParseThreshold(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}